@@ -270,6 +270,9 @@ func (cl *Client) Create(config model.Config) (string, error) {
 	if err := schema.Validate(config.Spec); err != nil {
 		return "", multierror.Prefix(err, "validation error:")
 	}
+	if err := model.ValidateAnnotations(config); err != nil {
+		return "", multierror.Prefix(err, "validation error:")
+	}
 
 	out, err := ConvertConfig(schema, config)
 	if err != nil {
@@ -299,6 +302,9 @@ func (cl *Client) Update(config model.Config) (string, error) {
 	if err := schema.Validate(config.Spec); err != nil {
 		return "", multierror.Prefix(err, "validation error:")
 	}
+	if err := model.ValidateAnnotations(config); err != nil {
+		return "", multierror.Prefix(err, "validation error:")
+	}
 
 	if config.ResourceVersion == "" {
 		return "", fmt.Errorf("revision is required")
@@ -115,6 +115,9 @@ func (cr *store) Create(config model.Config) (string, error) {
 	if err := schema.Validate(config.Spec); err != nil {
 		return "", err
 	}
+	if err := model.ValidateAnnotations(config); err != nil {
+		return "", err
+	}
 	ns, exists := cr.data[typ][config.Namespace]
 	if !exists {
 		ns = make(map[string]model.Config)
@@ -140,6 +143,9 @@ func (cr *store) Update(config model.Config) (string, error) {
 	if err := schema.Validate(config.Spec); err != nil {
 		return "", err
 	}
+	if err := model.ValidateAnnotations(config); err != nil {
+		return "", err
+	}
 
 	ns, exists := cr.data[typ][config.Namespace]
 	if !exists {
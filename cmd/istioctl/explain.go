@@ -0,0 +1,210 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy/envoy"
+)
+
+var (
+	explainCmd = &cobra.Command{
+		Use:   "explain <destination>",
+		Short: "Explain the routing decisions from a source to a destination service",
+		Long: `
+Lists the route rules and destination policies that apply to the traffic from
+the source service to the destination service port, in the order in which they
+are evaluated, and prints the resulting Envoy routes. Rules that are shadowed
+by a preceding rule matching all requests are marked as such.`,
+		Example: `
+# Explain the routes from version v1 of productpage to reviews
+istioctl explain reviews --source productpage --source-labels version=v1 --port http`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			ns, err := handleNamespaces("")
+			if err != nil {
+				return err
+			}
+			sourceNs := explainSourceNamespace
+			if sourceNs == "" {
+				sourceNs = ns
+			}
+
+			_, client, err := kube.CreateInterface(kubeconfig)
+			if err != nil {
+				return err
+			}
+			destination, err := explainService(client, args[0], ns)
+			if err != nil {
+				return err
+			}
+			port, err := explainPort(destination)
+			if err != nil {
+				return err
+			}
+
+			var instances []*model.ServiceInstance
+			if explainSource != "" {
+				source, err := explainService(client, explainSource, sourceNs)
+				if err != nil {
+					return err
+				}
+				labels := model.Labels{}
+				if explainSourceLabels != "" {
+					labels = model.ParseLabelsString(explainSourceLabels)
+				}
+				instances = append(instances, &model.ServiceInstance{
+					Service: source,
+					Labels:  labels,
+				})
+			}
+
+			configClient, err := crd.NewClient(kubeconfig, model.ConfigDescriptor{
+				model.RouteRule,
+				model.EgressRule,
+				model.DestinationPolicy,
+			}, explainDomain)
+			if err != nil {
+				return err
+			}
+
+			out := envoy.ExplainRoutes(model.MakeIstioStore(configClient), instances, destination, port)
+			return printExplanation(out)
+		},
+	}
+
+	explainSource          string
+	explainSourceNamespace string
+	explainSourceLabels    string
+	explainPortName        string
+	explainDomain          string
+)
+
+// explainService converts a Kubernetes service to the model representation
+// used by the route generation
+func explainService(client kubernetes.Interface, name, namespace string) (*model.Service, error) {
+	svc, err := client.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(model.PortList, 0, len(svc.Spec.Ports))
+	for _, port := range svc.Spec.Ports {
+		ports = append(ports, &model.Port{
+			Name:     port.Name,
+			Port:     int(port.Port),
+			Protocol: kube.ConvertProtocol(port.Name, port.Protocol),
+		})
+	}
+
+	return &model.Service{
+		Hostname: fmt.Sprintf("%s.%s.svc.%s", name, namespace, explainDomain),
+		Address:  svc.Spec.ClusterIP,
+		Ports:    ports,
+	}, nil
+}
+
+// explainPort selects the destination port by name or number, defaulting to
+// the only port of the service
+func explainPort(service *model.Service) (*model.Port, error) {
+	if explainPortName == "" {
+		if len(service.Ports) != 1 {
+			return nil, fmt.Errorf("service %q has %d ports, use --port to select one",
+				service.Hostname, len(service.Ports))
+		}
+		return service.Ports[0], nil
+	}
+
+	if port, exists := service.Ports.Get(explainPortName); exists {
+		return port, nil
+	}
+	if number, err := strconv.Atoi(explainPortName); err == nil {
+		for _, port := range service.Ports {
+			if port.Port == number {
+				return port, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("service %q has no port %q", service.Hostname, explainPortName)
+}
+
+func printExplanation(out *envoy.RouteExplanation) error {
+	var w tabwriter.Writer
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+
+	if len(out.Rules) == 0 {
+		fmt.Fprintf(&w, "No route rules apply\n")
+	} else {
+		fmt.Fprintf(&w, "ROUTE RULE\tNAMESPACE\tPRECEDENCE\tSTATUS\n")
+		for _, explained := range out.Rules {
+			status := "active"
+			if explained.Route == nil {
+				status = "shadowed"
+			}
+			rule := explained.Config.Spec.(*proxyconfig.RouteRule)
+			fmt.Fprintf(&w, "%s\t%s\t%d\t%s\n",
+				explained.Config.Name, explained.Config.Namespace, rule.Precedence, status)
+		}
+	}
+	fmt.Fprintln(&w)
+
+	if len(out.Policies) == 0 {
+		fmt.Fprintf(&w, "No destination policies apply\n")
+	} else {
+		fmt.Fprintf(&w, "CLUSTER\tDESTINATION POLICY\tNAMESPACE\n")
+		for _, explained := range out.Policies {
+			fmt.Fprintf(&w, "%s\t%s\t%s\n",
+				explained.Cluster, explained.Config.Name, explained.Config.Namespace)
+		}
+	}
+	fmt.Fprintln(&w)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	bytes, err := json.MarshalIndent(out.Routes, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(bytes))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+	explainCmd.PersistentFlags().StringVar(&explainSource, "source", "",
+		"Source service name; rules and policies with a source match are skipped if unset")
+	explainCmd.PersistentFlags().StringVar(&explainSourceNamespace, "source-namespace", "",
+		"Source service namespace, defaults to the destination namespace")
+	explainCmd.PersistentFlags().StringVar(&explainSourceLabels, "source-labels", "",
+		"Source instance labels; e.g. version=v1,env=prod")
+	explainCmd.PersistentFlags().StringVar(&explainPortName, "port", "",
+		"Destination service port name or number")
+	explainCmd.PersistentFlags().StringVar(&explainDomain, "domain", "cluster.local",
+		"DNS domain suffix of the cluster")
+}
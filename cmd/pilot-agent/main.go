@@ -16,7 +16,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -31,10 +33,16 @@ import (
 	"istio.io/pilot/model"
 	"istio.io/pilot/platform"
 	"istio.io/pilot/proxy"
+	"istio.io/pilot/proxy/ca"
 	"istio.io/pilot/proxy/envoy"
+	"istio.io/pilot/proxy/inprocess"
 	"istio.io/pilot/tools/version"
 )
 
+// localCAProvider signs the workload certificates with a CA certificate and
+// key read from files
+const localCAProvider = "local"
+
 var (
 	role            proxy.Node
 	serviceregistry platform.ServiceRegistry
@@ -55,6 +63,33 @@ var (
 	controlPlaneAuthPolicy string
 	customConfigFile       string
 
+	// name of the registered proxy backend
+	proxyBackend string
+
+	// bootstrap flags extending the proxy config
+	bootstrap envoy.BootstrapConfig
+
+	// prioritized discovery addresses overriding the discovery address
+	discoveryAddresses string
+
+	// maximum duration of the proxy drain on termination
+	terminationDrainDuration time.Duration
+
+	// restart policy of the failing proxy
+	retry = proxy.DefaultRetry
+
+	// port of the agent health endpoints
+	statusPort int
+
+	// mounted mesh config file providing the proxy config defaults
+	meshConfigFile string
+
+	// workload certificate provisioning flags
+	caProvider      string
+	localCACertFile string
+	localCAKeyFile  string
+	certOptions     = ca.DefaultOptions()
+
 	rootCmd = &cobra.Command{
 		Use:   "agent",
 		Short: "Istio Pilot agent",
@@ -108,60 +143,66 @@ var (
 
 			glog.V(2).Infof("Proxy role: %#v", role)
 
+			// the proxy config is set by the flags, or by the mesh config with
+			// the explicitly set flags overriding it
 			proxyConfig := proxyconfig.ProxyConfig{}
-
-			// set all flags
-			proxyConfig.CustomConfigFile = customConfigFile
-			proxyConfig.ConfigPath = configPath
-			proxyConfig.BinaryPath = binaryPath
-			proxyConfig.ServiceCluster = serviceCluster
-			proxyConfig.AvailabilityZone = availabilityZone
-			proxyConfig.DrainDuration = ptypes.DurationProto(drainDuration)
-			proxyConfig.ParentShutdownDuration = ptypes.DurationProto(parentShutdownDuration)
-			proxyConfig.DiscoveryAddress = discoveryAddress
-			proxyConfig.DiscoveryRefreshDelay = ptypes.DurationProto(discoveryRefreshDelay)
-			proxyConfig.ZipkinAddress = zipkinAddress
-			proxyConfig.ConnectTimeout = ptypes.DurationProto(connectTimeout)
-			proxyConfig.StatsdUdpAddress = statsdUDPAddress
-			proxyConfig.ProxyAdminPort = int32(proxyAdminPort)
-
-			var pilotSAN []string
-			switch controlPlaneAuthPolicy {
-			case proxyconfig.AuthenticationPolicy_NONE.String():
-				proxyConfig.ControlPlaneAuthPolicy = proxyconfig.AuthenticationPolicy_NONE
-			case proxyconfig.AuthenticationPolicy_MUTUAL_TLS.String():
-				var ns string
-				proxyConfig.ControlPlaneAuthPolicy = proxyconfig.AuthenticationPolicy_MUTUAL_TLS
-				if serviceregistry == platform.KubernetesRegistry {
-					partDiscoveryAddress := strings.Split(discoveryAddress, ":")
-					discoveryHostname := partDiscoveryAddress[0]
-					parts := strings.Split(discoveryHostname, ".")
-					if len(parts) == 1 {
-						// namespace of pilot is not part of discovery address use
-						// pod namespace e.g. istio-pilot:15003
-						ns = os.Getenv("POD_NAMESPACE")
-					} else {
-						// namespace is found in the discovery address
-						// e.g. istio-pilot.istio-system:15003
-						ns = parts[1]
-					}
+			var configSource *proxy.ConfigSource
+			if meshConfigFile != "" {
+				bootstrap.MeshConfigFile = meshConfigFile
+				configSource = &proxy.ConfigSource{
+					MeshConfigFile: meshConfigFile,
+					Override: func(config *proxyconfig.ProxyConfig) error {
+						return applyProxyFlags(c, config, false)
+					},
+				}
+				var err error
+				if proxyConfig, err = configSource.Load(); err != nil {
+					return err
 				}
-				pilotSAN = envoy.GetPilotSAN(pilotDomain, ns)
+			} else if err := applyProxyFlags(c, &proxyConfig, true); err != nil {
+				return err
 			}
 
-			// resolve statsd address
-			if proxyConfig.StatsdUdpAddress != "" {
-				addr, err := proxy.ResolveAddr(proxyConfig.StatsdUdpAddress)
-				if err != nil {
+			if discoveryAddresses != "" {
+				var err error
+				if bootstrap.DiscoveryAddresses, err = envoy.ParseDiscoveryAddresses(discoveryAddresses); err != nil {
 					return err
 				}
+			}
 
-				proxyConfig.StatsdUdpAddress = addr
+			// the pilots of all the discovery addresses are trusted
+			var pilotSAN []string
+			if proxyConfig.ControlPlaneAuthPolicy == proxyconfig.AuthenticationPolicy_MUTUAL_TLS {
+				addresses := []string{proxyConfig.DiscoveryAddress}
+				if len(bootstrap.DiscoveryAddresses) > 0 {
+					addresses = nil
+					for _, address := range bootstrap.DiscoveryAddresses {
+						addresses = append(addresses, address.Address)
+					}
+				}
+				seen := make(map[string]bool)
+				for _, address := range addresses {
+					for _, san := range envoy.GetPilotSAN(pilotDomain, pilotNamespace(address)) {
+						if !seen[san] {
+							seen[san] = true
+							pilotSAN = append(pilotSAN, san)
+						}
+					}
+				}
 			}
 
 			if err := model.ValidateProxyConfig(&proxyConfig); err != nil {
 				return err
 			}
+			if err := envoy.ValidateBootstrapConfig(bootstrap); err != nil {
+				return err
+			}
+			if err := proxy.ValidateRetry(retry); err != nil {
+				return err
+			}
+			if bootstrap.TemplateFile != "" && proxyConfig.CustomConfigFile != "" {
+				return errors.New("bootstrap template and custom config file are mutually exclusive")
+			}
 
 			if out, err := model.ToYAML(&proxyConfig); err != nil {
 				glog.V(2).Infof("Failed to serialize to YAML: %v", err)
@@ -169,37 +210,197 @@ var (
 				glog.V(2).Infof("Effective config: %s", out)
 			}
 
-			certs := []envoy.CertSource{
+			certs := []proxy.CertSource{
 				{
 					Directory: proxy.AuthCertsPath,
 					Files:     []string{proxy.CertChainFilename, proxy.KeyFilename, proxy.RootCertFilename},
+					Bundle: &proxy.CertBundle{
+						CertChain: proxy.CertChainFilename,
+						Key:       proxy.KeyFilename,
+						RootCert:  proxy.RootCertFilename,
+					},
 				},
 			}
 
 			if role.Type == proxy.Ingress {
-				certs = append(certs, envoy.CertSource{
+				certs = append(certs, proxy.CertSource{
 					Directory: proxy.IngressCertsPath,
 					Files:     []string{proxy.IngressCertFilename, proxy.IngressKeyFilename},
+					Bundle: &proxy.CertBundle{
+						CertChain: proxy.IngressCertFilename,
+						Key:       proxy.IngressKeyFilename,
+					},
 				})
 			}
 
 			glog.V(2).Infof("Monitored certs: %#v", certs)
 
-			envoyProxy := envoy.NewProxy(proxyConfig, role.ServiceNode())
-			agent := proxy.NewAgent(envoyProxy, proxy.DefaultRetry)
-			watcher := envoy.NewWatcher(proxyConfig, agent, role, certs, pilotSAN)
+			backend, err := proxy.NewBackend(proxyBackend, proxy.BackendOptions{
+				Config:   proxyConfig,
+				Node:     role,
+				PilotSAN: pilotSAN,
+			})
+			if err != nil {
+				return err
+			}
+			agent := proxy.NewAgent(backend.Proxy, retry, terminationDrainDuration)
+			watcher := proxy.NewWatcher(proxyConfig, backend, agent, certs, configSource)
 			ctx, cancel := context.WithCancel(context.Background())
-			go watcher.Run(ctx)
+
+			if caProvider != "" {
+				provisioner, err := newProvisioner()
+				if err != nil {
+					cancel()
+					return err
+				}
+
+				// the proxy starts with the provisioned certificates, and a
+				// failed attempt is retried in the background
+				rotation, err := provisioner.Provision()
+				if err != nil {
+					glog.Warningf("Failed to provision certificate: %v", err)
+					rotation = time.Now().Add(certOptions.RetryInterval)
+				}
+				go provisioner.Run(ctx, rotation)
+			}
+
+			if statusPort > 0 {
+				statusServer := &http.Server{
+					Addr:    fmt.Sprintf(":%d", statusPort),
+					Handler: proxy.NewStatusHandler(agent),
+				}
+				go func() {
+					if err := statusServer.ListenAndServe(); err != nil {
+						glog.Errorf("Agent status server stopped: %v", err)
+					}
+				}()
+			}
+
+			done := make(chan struct{})
+			go func() {
+				watcher.Run(ctx)
+				close(done)
+			}()
 
 			stop := make(chan struct{})
 			cmd.WaitSignal(stop)
 			<-stop
 			cancel()
+
+			// the proxy drains before terminating
+			<-done
 			return nil
 		},
 	}
 )
 
+// pilotNamespace returns the namespace of the pilot at the discovery address
+func pilotNamespace(discoveryAddress string) string {
+	if serviceregistry != platform.KubernetesRegistry {
+		return ""
+	}
+	partDiscoveryAddress := strings.Split(discoveryAddress, ":")
+	discoveryHostname := partDiscoveryAddress[0]
+	parts := strings.Split(discoveryHostname, ".")
+	if len(parts) == 1 {
+		// namespace of pilot is not part of discovery address use
+		// pod namespace e.g. istio-pilot:15003
+		return os.Getenv("POD_NAMESPACE")
+	}
+	// namespace is found in the discovery address
+	// e.g. istio-pilot.istio-system:15003
+	return parts[1]
+}
+
+// applyProxyFlags sets the proxy config fields from all the flags, or only
+// from the flags set on the command line, and resolves the statsd address
+func applyProxyFlags(c *cobra.Command, config *proxyconfig.ProxyConfig, all bool) error {
+	set := func(name string) bool {
+		return all || c.Flags().Changed(name)
+	}
+
+	if set("customConfigFile") {
+		config.CustomConfigFile = customConfigFile
+	}
+	if set("configPath") {
+		config.ConfigPath = configPath
+	}
+	if set("binaryPath") {
+		config.BinaryPath = binaryPath
+	}
+	if set("serviceCluster") {
+		config.ServiceCluster = serviceCluster
+	}
+	if set("availabilityZone") {
+		config.AvailabilityZone = availabilityZone
+	}
+	if set("drainDuration") {
+		config.DrainDuration = ptypes.DurationProto(drainDuration)
+	}
+	if set("parentShutdownDuration") {
+		config.ParentShutdownDuration = ptypes.DurationProto(parentShutdownDuration)
+	}
+	if set("discoveryAddress") {
+		config.DiscoveryAddress = discoveryAddress
+	}
+	if set("discoveryRefreshDelay") {
+		config.DiscoveryRefreshDelay = ptypes.DurationProto(discoveryRefreshDelay)
+	}
+	if set("zipkinAddress") {
+		config.ZipkinAddress = zipkinAddress
+	}
+	if set("connectTimeout") {
+		config.ConnectTimeout = ptypes.DurationProto(connectTimeout)
+	}
+	if set("statsdUdpAddress") {
+		config.StatsdUdpAddress = statsdUDPAddress
+	}
+	if set("proxyAdminPort") {
+		config.ProxyAdminPort = int32(proxyAdminPort)
+	}
+	if set("controlPlaneAuthPolicy") {
+		switch controlPlaneAuthPolicy {
+		case proxyconfig.AuthenticationPolicy_NONE.String():
+			config.ControlPlaneAuthPolicy = proxyconfig.AuthenticationPolicy_NONE
+		case proxyconfig.AuthenticationPolicy_MUTUAL_TLS.String():
+			config.ControlPlaneAuthPolicy = proxyconfig.AuthenticationPolicy_MUTUAL_TLS
+		}
+	}
+
+	// resolve statsd address
+	if config.StatsdUdpAddress != "" {
+		addr, err := proxy.ResolveAddr(config.StatsdUdpAddress)
+		if err != nil {
+			return err
+		}
+
+		config.StatsdUdpAddress = addr
+	}
+
+	return nil
+}
+
+// newProvisioner creates the workload certificate provisioner for the CA
+// provider
+func newProvisioner() (*ca.Provisioner, error) {
+	if err := ca.ValidateOptions(certOptions); err != nil {
+		return nil, err
+	}
+
+	var authority ca.CA
+	switch caProvider {
+	case localCAProvider:
+		var err error
+		if authority, err = ca.NewLocalCA(localCACertFile, localCAKeyFile); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported CA provider %q", caProvider)
+	}
+
+	return ca.NewProvisioner(authority, certOptions), nil
+}
+
 func timeDuration(dur *duration.Duration) time.Duration {
 	out, err := ptypes.Duration(dur)
 	if err != nil {
@@ -209,6 +410,12 @@ func timeDuration(dur *duration.Duration) time.Duration {
 }
 
 func init() {
+	// the Envoy backend reads the bootstrap flags when it is created
+	proxy.RegisterBackend(envoy.BackendName, func(options proxy.BackendOptions) (proxy.Backend, error) {
+		return envoy.NewBackend(options, bootstrap), nil
+	})
+	proxy.RegisterBackend(inprocess.BackendName, inprocess.NewBackend)
+
 	proxyCmd.PersistentFlags().StringVar((*string)(&serviceregistry), "serviceregistry",
 		string(platform.KubernetesRegistry),
 		fmt.Sprintf("Select the platform for service registry, options are {%s, %s, %s}",
@@ -237,6 +444,19 @@ func init() {
 	proxyCmd.PersistentFlags().DurationVar(&parentShutdownDuration, "parentShutdownDuration",
 		timeDuration(values.ParentShutdownDuration),
 		"The time in seconds that Envoy will wait before shutting down the parent process during a hot restart")
+	proxyCmd.PersistentFlags().DurationVar(&terminationDrainDuration, "terminationDrainDuration", 5*time.Second,
+		"The maximum time that the proxy drains the active connections on termination, "+
+			"must be shorter than the termination grace period of the pod")
+	proxyCmd.PersistentFlags().DurationVar(&retry.MaxInterval, "retryMaxInterval", retry.MaxInterval,
+		"The maximum delay between the restarts of a failing proxy, uncapped if 0")
+	proxyCmd.PersistentFlags().Float64Var(&retry.Jitter, "retryJitter", retry.Jitter,
+		"The fraction in [0, 1) by which the delays between the restarts are randomly reduced")
+	proxyCmd.PersistentFlags().BoolVar(&retry.KeepRetrying, "keepRetrying", retry.KeepRetrying,
+		"Keep restarting a failing proxy at the maximum interval and report not ready once the retry budget "+
+			"is exhausted, instead of exiting")
+	proxyCmd.PersistentFlags().IntVar(&statusPort, "statusPort", 0,
+		fmt.Sprintf("Port of the agent readiness (%s), liveness (%s), status (%s), and metrics (%s) endpoints, "+
+			"disabled if 0", proxy.ReadyPath, proxy.LivePath, proxy.StatusPath, proxy.MetricsPath))
 	proxyCmd.PersistentFlags().StringVar(&discoveryAddress, "discoveryAddress", values.DiscoveryAddress,
 		"Address of the discovery service exposing xDS (e.g. istio-pilot:8080)")
 	proxyCmd.PersistentFlags().DurationVar(&discoveryRefreshDelay, "discoveryRefreshDelay",
@@ -255,6 +475,36 @@ func init() {
 		values.ControlPlaneAuthPolicy.String(), "Control Plane Authentication Policy")
 	proxyCmd.PersistentFlags().StringVar(&customConfigFile, "customConfigFile", values.CustomConfigFile,
 		"Path to the generated configuration file directory")
+	proxyCmd.PersistentFlags().StringVar(&meshConfigFile, "meshConfig", "",
+		"Path to the mounted mesh config file providing the proxy config, overridden by the flags set "+
+			"on the command line, and the tracing backend, reloaded on changes")
+
+	proxyCmd.PersistentFlags().StringVar(&proxyBackend, "proxyBackend", envoy.BackendName,
+		fmt.Sprintf("Proxy backend managed by the agent, options are %v", proxy.Backends()))
+
+	bootstrapValues := envoy.DefaultBootstrapConfig()
+	proxyCmd.PersistentFlags().StringVar(&bootstrap.RateLimitAddress, "rateLimitAddress",
+		bootstrapValues.RateLimitAddress, "Address of the rate limit service (e.g. ratelimit:8081)")
+	proxyCmd.PersistentFlags().StringVar(&discoveryAddresses, "discoveryAddresses", "",
+		"Comma separated addresses of the discovery services with an optional priority, 0 being the highest "+
+			"(e.g. istio-pilot:15003,remote-pilot:15003=1), overriding the discovery address. "+
+			"The proxy fails over to a lower priority while no address of a higher priority is reachable")
+	proxyCmd.PersistentFlags().StringVar(&bootstrap.TemplateFile, "bootstrapTemplate",
+		bootstrapValues.TemplateFile, "Path to the Go template rendering the proxy configuration, "+
+			"reloaded on changes")
+
+	proxyCmd.PersistentFlags().StringVar(&caProvider, "caProvider", "",
+		fmt.Sprintf("CA provisioning the workload certificates, options are {%s}, disabled if empty",
+			localCAProvider))
+	proxyCmd.PersistentFlags().StringVar(&localCACertFile, "localCACertFile", "",
+		"Path to the CA certificate of the local CA provider")
+	proxyCmd.PersistentFlags().StringVar(&localCAKeyFile, "localCAKeyFile", "",
+		"Path to the CA key of the local CA provider")
+	proxyCmd.PersistentFlags().StringVar(&certOptions.Identity, "workloadIdentity", certOptions.Identity,
+		"URI subject alternate name of the provisioned workload certificate "+
+			"(e.g. spiffe://cluster.local/ns/default/sa/default)")
+	proxyCmd.PersistentFlags().DurationVar(&certOptions.TTL, "certTTL", certOptions.TTL,
+		"Requested validity of the provisioned workload certificates, rotated halfway through")
 
 	cmd.AddFlags(rootCmd)
 
@@ -89,6 +89,12 @@ var (
 				mesh = &defaultMesh
 				glog.Warningf("failed to read mesh configuration, using default: %v", fail)
 			}
+			extensions, fail := proxy.ReadMeshExtensions(flags.meshconfig)
+			if fail != nil {
+				defaultExtensions := proxy.DefaultMeshExtensions()
+				extensions = &defaultExtensions
+				glog.Warningf("failed to read mesh extensions, using default: %v", fail)
+			}
 
 			glog.V(2).Infof("mesh configuration %s", spew.Sdump(mesh))
 			glog.V(2).Infof("version %s", version.Line())
@@ -197,6 +203,7 @@ var (
 				ServiceDiscovery: serviceControllers,
 				ServiceAccounts:  serviceControllers,
 				MixerSAN:         mixerSAN,
+				Extensions:       *extensions,
 			}
 
 			// Set up discovery service
@@ -218,6 +225,8 @@ var (
 			flags.admissionArgs.ValidateNamespaces = []string{
 				flags.controllerOptions.WatchedNamespace,
 			}
+			flags.admissionArgs.ConfigStore = environment.IstioConfigStore
+			flags.admissionArgs.ServiceDiscovery = serviceControllers
 			admissionController, err := admit.NewController(client, flags.admissionArgs)
 			if err != nil {
 				return fmt.Errorf("failed to create validation admission controller: %v", err)
@@ -278,6 +287,9 @@ func init() {
 	discoveryCmd.PersistentFlags().DurationVar(&flags.admissionArgs.RegistrationDelay,
 		"admission-registration-delay", 5*time.Second,
 		"Time to delay webhook registration after starting webhook server")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.admissionArgs.ValidateReferences,
+		"admission-validate-references", false,
+		"Validate configuration against the config store and service registry, including deletions")
 
 	cmd.AddFlags(rootCmd)
 	rootCmd.AddCommand(discoveryCmd)
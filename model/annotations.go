@@ -0,0 +1,707 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Configuration features that are not yet part of the Istio API are carried
+// in annotations on the configuration objects. The annotation values are JSON
+// encoded and validated together with the object.
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+const (
+	// MirrorAnnotation on a route rule holds the destination service, encoded
+	// as an IstioService, that receives a copy of the routed requests. The
+	// optional whole "percent" field limits the percentage of the mirrored
+	// requests, and must be listed in the mirror percentages of the mesh
+	// config unless 0 or 100. The responses from the mirror destination are
+	// discarded.
+	MirrorAnnotation = "alpha.istio.io/mirror"
+
+	// HeadersAnnotation on a route rule or an ingress rule holds the
+	// HeaderOperations applied to the routed requests and their responses.
+	// The response header operations apply to all the responses of the
+	// proxy port routing the requests, and the request headers cannot be
+	// removed.
+	HeadersAnnotation = "alpha.istio.io/headers"
+
+	// CorsAnnotation on a route rule or an ingress rule holds the CorsPolicy
+	// for the cross-origin requests to the routed destination.
+	CorsAnnotation = "alpha.istio.io/cors"
+
+	// RetryOnAnnotation on a route rule holds the list of conditions, e.g.
+	// ["gateway-error", "reset"], under which the failed requests are
+	// retried. The default conditions are 5xx, connect-failure and
+	// refused-stream.
+	RetryOnAnnotation = "alpha.istio.io/retry-on"
+
+	// RateLimitsAnnotation on a route rule or an ingress rule holds the list
+	// of RateLimit descriptors sent to the rate limit service for the routed
+	// requests. The descriptors of an ingress rule are sent in addition to
+	// those of the route rules.
+	RateLimitsAnnotation = "alpha.istio.io/rate-limits"
+
+	// MaxRetriesAnnotation on a destination policy holds the maximum number
+	// of concurrent retries to the destination.
+	MaxRetriesAnnotation = "alpha.istio.io/max-retries"
+
+	// OutlierDetectionAnnotation on a destination policy holds the
+	// OutlierDetection settings for the destination. The settings override
+	// those derived from the simple circuit breaker.
+	OutlierDetectionAnnotation = "alpha.istio.io/outlier-detection"
+
+	// ConsistentHashAnnotation on a destination policy holds the
+	// ConsistentHash settings that select the ring hash load balancing for
+	// the destination in place of the load balancing name.
+	ConsistentHashAnnotation = "alpha.istio.io/consistent-hash"
+
+	// ConnectionPoolAnnotation on a destination policy holds the
+	// ConnectionPool settings for the upstream connections to the
+	// destination. The settings apply independently of the circuit breaker.
+	ConnectionPoolAnnotation = "alpha.istio.io/connection-pool"
+)
+
+const (
+	// UpstreamHTTP1 forces HTTP/1.1 on the upstream connections
+	UpstreamHTTP1 = "http1"
+
+	// UpstreamHTTP2 forces HTTP/2 on the upstream connections
+	UpstreamHTTP2 = "http2"
+)
+
+// retryConditions lists the supported HTTP and gRPC retry conditions
+var retryConditions = map[string]bool{
+	"5xx":                true,
+	"gateway-error":      true,
+	"connect-failure":    true,
+	"retriable-4xx":      true,
+	"refused-stream":     true,
+	"reset":              true,
+	"cancelled":          true,
+	"deadline-exceeded":  true,
+	"internal":           true,
+	"resource-exhausted": true,
+	"unavailable":        true,
+}
+
+// HeaderOperations lists the headers to add and to remove from the requests
+// and the responses.
+type HeaderOperations struct {
+	Request  *HeaderOperation `json:"request,omitempty"`
+	Response *HeaderOperation `json:"response,omitempty"`
+}
+
+// HeaderOperation maps the appended header names to their values and lists
+// the removed header names.
+type HeaderOperation struct {
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// decodeAnnotation unmarshals the annotation value into out and reports
+// whether the annotation is present.
+func decodeAnnotation(meta ConfigMeta, key string, out interface{}) (bool, error) {
+	value, exists := meta.Annotations[key]
+	if !exists {
+		return false, nil
+	}
+	if err := json.Unmarshal([]byte(value), out); err != nil {
+		return true, fmt.Errorf("invalid annotation %s: %v", key, err)
+	}
+	return true, nil
+}
+
+// CorsPolicy describes the cross-origin resource sharing responses.
+type CorsPolicy struct {
+	// AllowOrigin lists the origins allowed to make requests, "*" allows
+	// all origins
+	AllowOrigin []string `json:"allowOrigin"`
+
+	// AllowMethods lists the allowed HTTP methods
+	AllowMethods []string `json:"allowMethods,omitempty"`
+
+	// AllowHeaders lists the allowed request headers
+	AllowHeaders []string `json:"allowHeaders,omitempty"`
+
+	// ExposeHeaders lists the response headers the browsers may access
+	ExposeHeaders []string `json:"exposeHeaders,omitempty"`
+
+	// MaxAge is the duration, e.g. "24h", that the preflight responses may be
+	// cached for
+	MaxAge string `json:"maxAge,omitempty"`
+
+	// AllowCredentials indicates whether the requests may include credentials
+	AllowCredentials bool `json:"allowCredentials,omitempty"`
+}
+
+// OutlierDetection describes the ejection of the unhealthy hosts from the
+// load balancing pool. Zero values leave the proxy defaults in place.
+type OutlierDetection struct {
+	// Interval is the duration, e.g. "10s", between the ejection sweeps
+	Interval string `json:"interval,omitempty"`
+
+	// BaseEjectionTime is the duration of the first ejection of a host,
+	// which grows with the number of times the host is ejected
+	BaseEjectionTime string `json:"baseEjectionTime,omitempty"`
+
+	// MaxEjectionPercent is the maximum percentage of the ejected hosts
+	MaxEjectionPercent int `json:"maxEjectionPercent,omitempty"`
+
+	// ConsecutiveErrors is the number of consecutive 5xx responses that
+	// eject a host
+	ConsecutiveErrors int `json:"consecutiveErrors,omitempty"`
+
+	// ConsecutiveGatewayErrors is the number of consecutive 502, 503, and
+	// 504 responses that eject a host
+	ConsecutiveGatewayErrors int `json:"consecutiveGatewayErrors,omitempty"`
+
+	// SuccessRate enables the ejection of the hosts with a low success rate
+	// compared to the other hosts. The ejection is disabled if unset.
+	SuccessRate *SuccessRateEjection `json:"successRate,omitempty"`
+}
+
+// SuccessRateEjection describes the success rate statistics of the hosts
+type SuccessRateEjection struct {
+	// MinimumHosts is the number of hosts with enough requests required to
+	// compute the statistics
+	MinimumHosts int `json:"minimumHosts,omitempty"`
+
+	// RequestVolume is the number of requests in an interval required to
+	// include a host in the statistics
+	RequestVolume int `json:"requestVolume,omitempty"`
+
+	// StdevFactor ejects the hosts with the success rate below the mean by
+	// more than the factor times the standard deviation
+	StdevFactor float64 `json:"stdevFactor,omitempty"`
+}
+
+// ConsistentHash describes the hash key for the ring hash load balancing
+type ConsistentHash struct {
+	// Header is the name of the request header hashed to pick a host
+	Header string `json:"header"`
+
+	// MinimumRingSize is the minimum number of entries in the hash ring
+	MinimumRingSize int `json:"minimumRingSize,omitempty"`
+}
+
+// ConnectionPool describes the upstream connections to a destination. Zero
+// values leave the proxy defaults in place.
+type ConnectionPool struct {
+	// Protocol is either UpstreamHTTP1 or UpstreamHTTP2 and overrides the
+	// protocol derived from the HTTP port of the destination
+	Protocol string `json:"protocol,omitempty"`
+
+	// MaxPendingRequests is the maximum number of requests waiting for a
+	// connection
+	MaxPendingRequests int `json:"maxPendingRequests,omitempty"`
+
+	// MaxRequestsPerConnection is the maximum number of requests on a
+	// connection; the value 1 disables the connection reuse
+	MaxRequestsPerConnection int `json:"maxRequestsPerConnection,omitempty"`
+}
+
+// RateLimit describes a descriptor composed of the entries of its actions in
+// order. The request is not rate limited by the descriptor if an entry is
+// missing, e.g. if the request lacks the header.
+type RateLimit struct {
+	// Actions produce the descriptor entries
+	Actions []RateLimitAction `json:"actions"`
+}
+
+// RateLimitAction produces a descriptor entry. Exactly one of the fields is
+// set.
+type RateLimitAction struct {
+	// Header adds the value of the request header
+	Header *HeaderDescriptor `json:"header,omitempty"`
+
+	// SourceService adds the service cluster of the source proxy
+	SourceService bool `json:"sourceService,omitempty"`
+
+	// Path adds the request path
+	Path bool `json:"path,omitempty"`
+
+	// RemoteAddress adds the address of the client
+	RemoteAddress bool `json:"remoteAddress,omitempty"`
+
+	// GenericKey adds the fixed value
+	GenericKey string `json:"genericKey,omitempty"`
+}
+
+// HeaderDescriptor names the descriptor entry for a request header
+type HeaderDescriptor struct {
+	// Name of the request header
+	Name string `json:"name"`
+
+	// DescriptorKey is the key of the descriptor entry
+	DescriptorKey string `json:"descriptorKey"`
+}
+
+// RouteMirror returns the mirror destination of a route rule or nil if the
+// requests are not mirrored.
+func RouteMirror(meta ConfigMeta) (*proxyconfig.IstioService, error) {
+	mirror := &proxyconfig.IstioService{}
+	if exists, err := decodeAnnotation(meta, MirrorAnnotation, mirror); !exists || err != nil {
+		return nil, err
+	}
+	return mirror, nil
+}
+
+// RouteMirrorPercent returns the percentage of the mirrored requests of a route
+// rule or nil if all the requests are mirrored.
+func RouteMirrorPercent(meta ConfigMeta) (*int32, error) {
+	var mirror struct {
+		Percent *int32 `json:"percent"`
+	}
+	if exists, err := decodeAnnotation(meta, MirrorAnnotation, &mirror); !exists || err != nil {
+		return nil, err
+	}
+	return mirror.Percent, nil
+}
+
+// RouteHeaderOperations returns the header operations of a route rule or an
+// ingress rule or nil if the headers are not modified.
+func RouteHeaderOperations(meta ConfigMeta) (*HeaderOperations, error) {
+	ops := &HeaderOperations{}
+	if exists, err := decodeAnnotation(meta, HeadersAnnotation, ops); !exists || err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// RouteCorsPolicy returns the CORS policy of a route rule or an ingress rule
+// or nil if the cross-origin requests are not handled by the proxy.
+func RouteCorsPolicy(meta ConfigMeta) (*CorsPolicy, error) {
+	cors := &CorsPolicy{}
+	if exists, err := decodeAnnotation(meta, CorsAnnotation, cors); !exists || err != nil {
+		return nil, err
+	}
+	return cors, nil
+}
+
+// ValidateCorsPolicy checks that the CORS policy allows some origins and that
+// the methods, the headers, and the max age are well formed
+func ValidateCorsPolicy(cors *CorsPolicy) (errs error) {
+	if len(cors.AllowOrigin) == 0 {
+		errs = multierror.Append(errs, errors.New("at least one allowed origin is required"))
+	}
+	for _, origin := range cors.AllowOrigin {
+		if origin == "" {
+			errs = multierror.Append(errs, errors.New("origin must not be empty"))
+		}
+	}
+
+	for _, method := range cors.AllowMethods {
+		if method == "" || strings.ToUpper(method) != method || strings.ContainsAny(method, " ,") {
+			errs = multierror.Append(errs, fmt.Errorf("method %q must be an upper case token", method))
+		}
+	}
+
+	for _, name := range append(append([]string{}, cors.AllowHeaders...), cors.ExposeHeaders...) {
+		if err := validateHeaderOperationName(name); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	if cors.MaxAge != "" {
+		if maxAge, err := time.ParseDuration(cors.MaxAge); err != nil {
+			errs = multierror.Append(errs, err)
+		} else if maxAge < 0 || maxAge%time.Second != 0 {
+			errs = multierror.Append(errs, fmt.Errorf("max age %s must be a non-negative number of seconds", cors.MaxAge))
+		}
+	}
+
+	return
+}
+
+// RouteRetryOn returns the retry conditions of a route rule or nil for the
+// default conditions.
+func RouteRetryOn(meta ConfigMeta) ([]string, error) {
+	var conditions []string
+	if exists, err := decodeAnnotation(meta, RetryOnAnnotation, &conditions); !exists || err != nil {
+		return nil, err
+	}
+	return conditions, nil
+}
+
+// RouteRateLimits returns the rate limit descriptors of a route rule or an
+// ingress rule, or nil if the requests are not rate limited.
+func RouteRateLimits(meta ConfigMeta) ([]RateLimit, error) {
+	var limits []RateLimit
+	if exists, err := decodeAnnotation(meta, RateLimitsAnnotation, &limits); !exists || err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// ValidateRateLimits checks that every descriptor has actions and that every
+// action produces exactly one well formed entry
+func ValidateRateLimits(limits []RateLimit) (errs error) {
+	if len(limits) == 0 {
+		errs = multierror.Append(errs, errors.New("at least one rate limit is required"))
+	}
+	for i, limit := range limits {
+		if len(limit.Actions) == 0 {
+			errs = multierror.Append(errs, fmt.Errorf("rate limit %d has no actions", i))
+		}
+		for _, action := range limit.Actions {
+			if err := validateRateLimitAction(action); err != nil {
+				errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("rate limit %d:", i)))
+			}
+		}
+	}
+	return
+}
+
+func validateRateLimitAction(action RateLimitAction) (errs error) {
+	entries := 0
+	if header := action.Header; header != nil {
+		entries++
+		if err := validateHeaderOperationName(header.Name); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		if header.DescriptorKey == "" {
+			errs = multierror.Append(errs, fmt.Errorf("header %q requires a descriptor key", header.Name))
+		}
+	}
+	for _, set := range []bool{action.SourceService, action.Path, action.RemoteAddress, action.GenericKey != ""} {
+		if set {
+			entries++
+		}
+	}
+	if entries != 1 {
+		errs = multierror.Append(errs,
+			errors.New("exactly one of header, sourceService, path, remoteAddress, or genericKey must be set"))
+	}
+	return
+}
+
+// PolicyMaxRetries returns the maximum number of concurrent retries of a
+// destination policy or zero if the proxy default applies.
+func PolicyMaxRetries(meta ConfigMeta) (int, error) {
+	var maxRetries int
+	if _, err := decodeAnnotation(meta, MaxRetriesAnnotation, &maxRetries); err != nil {
+		return 0, err
+	}
+	return maxRetries, nil
+}
+
+// PolicyOutlierDetection returns the outlier detection settings of a
+// destination policy or nil if the settings are derived from the circuit
+// breaker.
+func PolicyOutlierDetection(meta ConfigMeta) (*OutlierDetection, error) {
+	outlier := &OutlierDetection{}
+	if exists, err := decodeAnnotation(meta, OutlierDetectionAnnotation, outlier); !exists || err != nil {
+		return nil, err
+	}
+	return outlier, nil
+}
+
+// PolicyConsistentHash returns the ring hash settings of a destination policy
+// or nil if the destination is not load balanced by hash.
+func PolicyConsistentHash(meta ConfigMeta) (*ConsistentHash, error) {
+	hash := &ConsistentHash{}
+	if exists, err := decodeAnnotation(meta, ConsistentHashAnnotation, hash); !exists || err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// ValidateConsistentHash checks that the hash key is a well formed header
+func ValidateConsistentHash(hash *ConsistentHash) (errs error) {
+	if hash.Header == "" {
+		errs = multierror.Append(errs, errors.New("header must be set"))
+	} else if err := ValidateHTTPHeaderName(hash.Header); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("header %q %v", hash.Header, err))
+	}
+
+	if hash.MinimumRingSize < 0 {
+		errs = multierror.Append(errs, errors.New("minimumRingSize invalid: must not be negative"))
+	}
+	return
+}
+
+// PolicyConnectionPool returns the connection pool settings of a destination
+// policy or nil if the proxy defaults apply.
+func PolicyConnectionPool(meta ConfigMeta) (*ConnectionPool, error) {
+	pool := &ConnectionPool{}
+	if exists, err := decodeAnnotation(meta, ConnectionPoolAnnotation, pool); !exists || err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// ValidateConnectionPool checks the upstream protocol and the limits of the
+// connection pool settings
+func ValidateConnectionPool(pool *ConnectionPool) (errs error) {
+	switch pool.Protocol {
+	case "", UpstreamHTTP1, UpstreamHTTP2:
+	default:
+		errs = multierror.Append(errs, fmt.Errorf("protocol %q invalid: must be %s or %s",
+			pool.Protocol, UpstreamHTTP1, UpstreamHTTP2))
+	}
+
+	if pool.MaxPendingRequests < 0 {
+		errs = multierror.Append(errs, errors.New("maxPendingRequests invalid: must not be negative"))
+	}
+	if pool.MaxRequestsPerConnection < 0 {
+		errs = multierror.Append(errs, errors.New("maxRequestsPerConnection invalid: must not be negative"))
+	}
+	return
+}
+
+// ValidateOutlierDetection checks the ranges of the outlier detection settings
+func ValidateOutlierDetection(outlier *OutlierDetection) (errs error) {
+	if err := validateMillisDuration(outlier.Interval); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "interval invalid:"))
+	}
+	if err := validateMillisDuration(outlier.BaseEjectionTime); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "baseEjectionTime invalid:"))
+	}
+
+	if outlier.MaxEjectionPercent < 0 || outlier.MaxEjectionPercent > 100 {
+		errs = multierror.Append(errs, errors.New("maxEjectionPercent invalid: must be in range [0..100]"))
+	}
+	if outlier.ConsecutiveErrors < 0 {
+		errs = multierror.Append(errs, errors.New("consecutiveErrors invalid: must not be negative"))
+	}
+	if outlier.ConsecutiveGatewayErrors < 0 {
+		errs = multierror.Append(errs, errors.New("consecutiveGatewayErrors invalid: must not be negative"))
+	}
+
+	if rate := outlier.SuccessRate; rate != nil {
+		if rate.MinimumHosts < 0 || rate.RequestVolume < 0 || rate.StdevFactor < 0 {
+			errs = multierror.Append(errs, errors.New("successRate invalid: values must not be negative"))
+		}
+	}
+
+	return
+}
+
+// validateMillisDuration checks that an optional duration string is a
+// positive number of milliseconds
+func validateMillisDuration(value string) error {
+	if value == "" {
+		return nil
+	}
+	dur, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if dur < time.Millisecond || dur%time.Millisecond != 0 {
+		return errors.New("duration must be a positive number of milliseconds")
+	}
+	return nil
+}
+
+// ValidateRetryOn checks that the retry conditions are supported
+func ValidateRetryOn(conditions []string) (errs error) {
+	if len(conditions) == 0 {
+		errs = multierror.Append(errs, errors.New("at least one retry condition is required"))
+	}
+	for _, condition := range conditions {
+		if !retryConditions[condition] {
+			errs = multierror.Append(errs, fmt.Errorf("unsupported retry condition %q", condition))
+		}
+	}
+	return
+}
+
+// ValidateHeaderOperations checks that the header names are valid and rejects
+// the request header removals, which the proxy routes do not support
+func ValidateHeaderOperations(ops *HeaderOperations) (errs error) {
+	for _, op := range []*HeaderOperation{ops.Request, ops.Response} {
+		if op == nil {
+			continue
+		}
+		for name := range op.Add {
+			if err := validateHeaderOperationName(name); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+		for _, name := range op.Remove {
+			if err := validateHeaderOperationName(name); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+	if ops.Request != nil && len(ops.Request.Remove) > 0 {
+		errs = multierror.Append(errs, errors.New("Istio does not support the request header removal yet"))
+	}
+	return
+}
+
+func validateHeaderOperationName(name string) error {
+	if name == "" {
+		return errors.New("header name must not be empty")
+	}
+	if err := ValidateHTTPHeaderName(name); err != nil {
+		return fmt.Errorf("header %q %v", name, err)
+	}
+	return nil
+}
+
+// ValidateAnnotations checks the annotations that extend the configuration
+// object. Unknown annotations are ignored.
+func ValidateAnnotations(config Config) (errs error) {
+	var validators []func(ConfigMeta) error
+	switch config.Type {
+	case RouteRule.Type:
+		validators = []func(ConfigMeta) error{validateMirrorAnnotation, validateHeadersAnnotation,
+			validateCorsAnnotation, validateRetryOnAnnotation, validateRateLimitsAnnotation}
+	case IngressRule.Type:
+		validators = []func(ConfigMeta) error{validateHeadersAnnotation, validateCorsAnnotation,
+			validateRateLimitsAnnotation}
+	case DestinationPolicy.Type:
+		validators = []func(ConfigMeta) error{validateMaxRetriesAnnotation, validateOutlierDetectionAnnotation,
+			validateConsistentHashAnnotation, validateConnectionPoolAnnotation}
+	}
+
+	for _, validate := range validators {
+		if err := validate(config.ConfigMeta); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return
+}
+
+func validateMirrorAnnotation(meta ConfigMeta) error {
+	mirror, err := RouteMirror(meta)
+	if err != nil || mirror == nil {
+		return err
+	}
+	var errs error
+	if err := ValidateIstioService(mirror); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	percent, err := RouteMirrorPercent(meta)
+	if err != nil {
+		return err
+	}
+	if percent != nil {
+		if err := ValidatePercent(*percent); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "percent invalid:"))
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("invalid annotation %s: %v", MirrorAnnotation, errs)
+	}
+	return nil
+}
+
+func validateHeadersAnnotation(meta ConfigMeta) error {
+	ops, err := RouteHeaderOperations(meta)
+	if err != nil || ops == nil {
+		return err
+	}
+	if err := ValidateHeaderOperations(ops); err != nil {
+		return fmt.Errorf("invalid annotation %s: %v", HeadersAnnotation, err)
+	}
+	return nil
+}
+
+func validateCorsAnnotation(meta ConfigMeta) error {
+	cors, err := RouteCorsPolicy(meta)
+	if err != nil || cors == nil {
+		return err
+	}
+	if err := ValidateCorsPolicy(cors); err != nil {
+		return fmt.Errorf("invalid annotation %s: %v", CorsAnnotation, err)
+	}
+	return nil
+}
+
+func validateRetryOnAnnotation(meta ConfigMeta) error {
+	if _, exists := meta.Annotations[RetryOnAnnotation]; !exists {
+		return nil
+	}
+	conditions, err := RouteRetryOn(meta)
+	if err != nil {
+		return err
+	}
+	if err := ValidateRetryOn(conditions); err != nil {
+		return fmt.Errorf("invalid annotation %s: %v", RetryOnAnnotation, err)
+	}
+	return nil
+}
+
+func validateRateLimitsAnnotation(meta ConfigMeta) error {
+	if _, exists := meta.Annotations[RateLimitsAnnotation]; !exists {
+		return nil
+	}
+	limits, err := RouteRateLimits(meta)
+	if err != nil {
+		return err
+	}
+	if err := ValidateRateLimits(limits); err != nil {
+		return fmt.Errorf("invalid annotation %s: %v", RateLimitsAnnotation, err)
+	}
+	return nil
+}
+
+func validateMaxRetriesAnnotation(meta ConfigMeta) error {
+	if _, exists := meta.Annotations[MaxRetriesAnnotation]; !exists {
+		return nil
+	}
+	maxRetries, err := PolicyMaxRetries(meta)
+	if err != nil {
+		return err
+	}
+	if maxRetries <= 0 {
+		return fmt.Errorf("invalid annotation %s: must be positive", MaxRetriesAnnotation)
+	}
+	return nil
+}
+
+func validateOutlierDetectionAnnotation(meta ConfigMeta) error {
+	outlier, err := PolicyOutlierDetection(meta)
+	if err != nil || outlier == nil {
+		return err
+	}
+	if err := ValidateOutlierDetection(outlier); err != nil {
+		return fmt.Errorf("invalid annotation %s: %v", OutlierDetectionAnnotation, err)
+	}
+	return nil
+}
+
+func validateConsistentHashAnnotation(meta ConfigMeta) error {
+	hash, err := PolicyConsistentHash(meta)
+	if err != nil || hash == nil {
+		return err
+	}
+	if err := ValidateConsistentHash(hash); err != nil {
+		return fmt.Errorf("invalid annotation %s: %v", ConsistentHashAnnotation, err)
+	}
+	return nil
+}
+
+func validateConnectionPoolAnnotation(meta ConfigMeta) error {
+	pool, err := PolicyConnectionPool(meta)
+	if err != nil || pool == nil {
+		return err
+	}
+	if err := ValidateConnectionPool(pool); err != nil {
+		return fmt.Errorf("invalid annotation %s: %v", ConnectionPoolAnnotation, err)
+	}
+	return nil
+}
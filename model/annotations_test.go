@@ -0,0 +1,165 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+)
+
+func TestValidateAnnotations(t *testing.T) {
+	cases := []struct {
+		name        string
+		typ         string
+		annotations map[string]string
+		valid       bool
+	}{
+		{name: "no annotations", typ: RouteRule.Type, valid: true},
+		{name: "unknown annotation", typ: RouteRule.Type,
+			annotations: map[string]string{"example.com/note": "{"}, valid: true},
+		{name: "mirror", typ: RouteRule.Type,
+			annotations: map[string]string{MirrorAnnotation: `{"name":"reviews","labels":{"version":"v2"}}`}, valid: true},
+		{name: "mirror percent", typ: RouteRule.Type,
+			annotations: map[string]string{MirrorAnnotation: `{"name":"reviews","percent":10}`}, valid: true},
+		{name: "mirror percent above 100", typ: RouteRule.Type,
+			annotations: map[string]string{MirrorAnnotation: `{"name":"reviews","percent":150}`}, valid: false},
+		{name: "mirror percent not whole", typ: RouteRule.Type,
+			annotations: map[string]string{MirrorAnnotation: `{"name":"reviews","percent":0.5}`}, valid: false},
+		{name: "mirror not JSON", typ: RouteRule.Type,
+			annotations: map[string]string{MirrorAnnotation: "reviews"}, valid: false},
+		{name: "mirror without name", typ: RouteRule.Type,
+			annotations: map[string]string{MirrorAnnotation: `{"labels":{"version":"v2"}}`}, valid: false},
+		{name: "mirror with bad labels", typ: RouteRule.Type,
+			annotations: map[string]string{MirrorAnnotation: `{"name":"reviews","labels":{"@":"v2"}}`}, valid: false},
+		{name: "headers", typ: RouteRule.Type,
+			annotations: map[string]string{HeadersAnnotation: `{"request":{"add":{"x-tenant":"blue"}}}`}, valid: true},
+		{name: "headers on ingress", typ: IngressRule.Type,
+			annotations: map[string]string{HeadersAnnotation: `{"request":{"add":{"x-env":"prod"}}}`}, valid: true},
+		{name: "headers request removal", typ: RouteRule.Type,
+			annotations: map[string]string{HeadersAnnotation: `{"request":{"remove":["x-internal"]}}`}, valid: false},
+		{name: "headers response addition", typ: IngressRule.Type,
+			annotations: map[string]string{HeadersAnnotation: `{"response":{"add":{"x-env":"prod"}}}`}, valid: true},
+		{name: "headers response removal", typ: IngressRule.Type,
+			annotations: map[string]string{HeadersAnnotation: `{"response":{"remove":["x-internal"]}}`}, valid: true},
+		{name: "headers response upper case removal", typ: RouteRule.Type,
+			annotations: map[string]string{HeadersAnnotation: `{"response":{"remove":["Server"]}}`}, valid: false},
+		{name: "headers upper case", typ: IngressRule.Type,
+			annotations: map[string]string{HeadersAnnotation: `{"request":{"add":{"X-Tenant":"blue"}}}`}, valid: false},
+		{name: "headers empty name", typ: RouteRule.Type,
+			annotations: map[string]string{HeadersAnnotation: `{"request":{"add":{"":"blue"}}}`}, valid: false},
+		{name: "headers not JSON", typ: RouteRule.Type,
+			annotations: map[string]string{HeadersAnnotation: "x-tenant=blue"}, valid: false},
+		{name: "cors", typ: IngressRule.Type,
+			annotations: map[string]string{CorsAnnotation: `{"allowOrigin":["*"],"allowMethods":["GET","POST"],` +
+				`"allowHeaders":["x-tenant"],"maxAge":"24h","allowCredentials":true}`},
+			valid: true},
+		{name: "cors without origin", typ: RouteRule.Type,
+			annotations: map[string]string{CorsAnnotation: `{"allowMethods":["GET"]}`}, valid: false},
+		{name: "cors lower case method", typ: RouteRule.Type,
+			annotations: map[string]string{CorsAnnotation: `{"allowOrigin":["*"],"allowMethods":["get"]}`}, valid: false},
+		{name: "cors bad max age", typ: RouteRule.Type,
+			annotations: map[string]string{CorsAnnotation: `{"allowOrigin":["*"],"maxAge":"1.5s"}`}, valid: false},
+		{name: "retry on", typ: RouteRule.Type,
+			annotations: map[string]string{RetryOnAnnotation: `["gateway-error","reset","unavailable"]`}, valid: true},
+		{name: "retry on empty", typ: RouteRule.Type,
+			annotations: map[string]string{RetryOnAnnotation: `[]`}, valid: false},
+		{name: "retry on unknown", typ: RouteRule.Type,
+			annotations: map[string]string{RetryOnAnnotation: `["5xx","always"]`}, valid: false},
+		{name: "rate limits", typ: RouteRule.Type,
+			annotations: map[string]string{RateLimitsAnnotation: `[{"actions":[{"sourceService":true},
+				{"header":{"name":"x-api-key","descriptorKey":"key"}}]},{"actions":[{"path":true}]}]`},
+			valid: true},
+		{name: "rate limits on ingress", typ: IngressRule.Type,
+			annotations: map[string]string{RateLimitsAnnotation: `[{"actions":[{"remoteAddress":true}]}]`}, valid: true},
+		{name: "rate limits empty", typ: RouteRule.Type,
+			annotations: map[string]string{RateLimitsAnnotation: "[]"}, valid: false},
+		{name: "rate limit without actions", typ: RouteRule.Type,
+			annotations: map[string]string{RateLimitsAnnotation: `[{"actions":[]}]`}, valid: false},
+		{name: "rate limit action with two entries", typ: RouteRule.Type,
+			annotations: map[string]string{RateLimitsAnnotation: `[{"actions":[{"path":true,"remoteAddress":true}]}]`}},
+		{name: "rate limit header without key", typ: RouteRule.Type,
+			annotations: map[string]string{RateLimitsAnnotation: `[{"actions":[{"header":{"name":"x-api-key"}}]}]`}},
+		{name: "max retries", typ: DestinationPolicy.Type,
+			annotations: map[string]string{MaxRetriesAnnotation: "10"}, valid: true},
+		{name: "max retries zero", typ: DestinationPolicy.Type,
+			annotations: map[string]string{MaxRetriesAnnotation: "0"}, valid: false},
+		{name: "max retries not a number", typ: DestinationPolicy.Type,
+			annotations: map[string]string{MaxRetriesAnnotation: "many"}, valid: false},
+		{name: "outlier detection", typ: DestinationPolicy.Type,
+			annotations: map[string]string{OutlierDetectionAnnotation: `{"interval":"5s","baseEjectionTime":"30s",
+				"maxEjectionPercent":50,"consecutiveGatewayErrors":3,"successRate":{"minimumHosts":3,"stdevFactor":1.9}}`},
+			valid: true},
+		{name: "outlier detection percent above 100", typ: DestinationPolicy.Type,
+			annotations: map[string]string{OutlierDetectionAnnotation: `{"maxEjectionPercent":101}`}, valid: false},
+		{name: "outlier detection sub-millisecond interval", typ: DestinationPolicy.Type,
+			annotations: map[string]string{OutlierDetectionAnnotation: `{"interval":"1.5ms"}`}, valid: false},
+		{name: "outlier detection bad ejection time", typ: DestinationPolicy.Type,
+			annotations: map[string]string{OutlierDetectionAnnotation: `{"baseEjectionTime":"x"}`}, valid: false},
+		{name: "outlier detection negative errors", typ: DestinationPolicy.Type,
+			annotations: map[string]string{OutlierDetectionAnnotation: `{"consecutiveErrors":-1}`}, valid: false},
+		{name: "outlier detection negative success rate", typ: DestinationPolicy.Type,
+			annotations: map[string]string{OutlierDetectionAnnotation: `{"successRate":{"requestVolume":-1}}`}, valid: false},
+		{name: "consistent hash header", typ: DestinationPolicy.Type,
+			annotations: map[string]string{ConsistentHashAnnotation: `{"header":"x-user","minimumRingSize":1024}`},
+			valid:       true},
+		{name: "consistent hash without key", typ: DestinationPolicy.Type,
+			annotations: map[string]string{ConsistentHashAnnotation: `{"minimumRingSize":1024}`}, valid: false},
+		{name: "consistent hash upper case header", typ: DestinationPolicy.Type,
+			annotations: map[string]string{ConsistentHashAnnotation: `{"header":"X-User"}`}, valid: false},
+		{name: "connection pool", typ: DestinationPolicy.Type,
+			annotations: map[string]string{ConnectionPoolAnnotation: `{"protocol":"http2","maxPendingRequests":100,
+				"maxRequestsPerConnection":1}`},
+			valid: true},
+		{name: "connection pool unknown protocol", typ: DestinationPolicy.Type,
+			annotations: map[string]string{ConnectionPoolAnnotation: `{"protocol":"spdy"}`}, valid: false},
+		{name: "connection pool negative pending", typ: DestinationPolicy.Type,
+			annotations: map[string]string{ConnectionPoolAnnotation: `{"maxPendingRequests":-1}`}, valid: false},
+		{name: "mirror on policy", typ: DestinationPolicy.Type,
+			annotations: map[string]string{MirrorAnnotation: "reviews"}, valid: true},
+	}
+
+	for _, c := range cases {
+		config := Config{ConfigMeta: ConfigMeta{Type: c.typ, Name: "test", Annotations: c.annotations}}
+		if err := ValidateAnnotations(config); (err == nil) != c.valid {
+			t.Errorf("%s: ValidateAnnotations() => got error %v, want valid %t", c.name, err, c.valid)
+		}
+	}
+}
+
+func TestRouteMirror(t *testing.T) {
+	mirror, err := RouteMirror(ConfigMeta{})
+	if mirror != nil || err != nil {
+		t.Errorf("RouteMirror() => got %v, %v for no annotation", mirror, err)
+	}
+
+	mirror, err = RouteMirror(ConfigMeta{Annotations: map[string]string{
+		MirrorAnnotation: `{"name":"reviews","namespace":"test"}`,
+	}})
+	if err != nil || mirror == nil || mirror.Name != "reviews" || mirror.Namespace != "test" {
+		t.Errorf("RouteMirror() => got %v, %v", mirror, err)
+	}
+
+	percent, err := RouteMirrorPercent(ConfigMeta{Annotations: map[string]string{
+		MirrorAnnotation: `{"name":"reviews","namespace":"test"}`,
+	}})
+	if percent != nil || err != nil {
+		t.Errorf("RouteMirrorPercent() => got %v, %v, want all the requests mirrored", percent, err)
+	}
+	percent, err = RouteMirrorPercent(ConfigMeta{Annotations: map[string]string{
+		MirrorAnnotation: `{"name":"reviews","percent":0}`,
+	}})
+	if percent == nil || *percent != 0 || err != nil {
+		t.Errorf("RouteMirrorPercent() => got %v, %v, want 0", percent, err)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	multierror "github.com/hashicorp/go-multierror"
@@ -530,3 +531,238 @@ func RejectConflictingEgressRules(egressRules map[string]*proxyconfig.EgressRule
 
 	return filteredEgressRules, errs
 }
+
+// ValidateReferences performs semantic validation of a configuration object
+// against the current contents of the config store and the service registry.
+// Schema validation only inspects a single object in isolation, whereas these
+// checks catch mistakes that produce broken proxy configuration in the context
+// of other objects:
+// - route rules referring to unknown services or label subsets
+// - route rules with equal precedence and match conditions for the same destination
+// - egress rules with a duplicate destination domain
+// - destination policies shadowing each other
+// The supplied configuration takes the place of any stored object with the same key.
+func ValidateReferences(config Config, store IstioConfigStore, discovery ServiceDiscovery) error {
+	// objects without a destination are rejected by the schema validation
+	switch rule := config.Spec.(type) {
+	case *proxyconfig.RouteRule:
+		if rule.Destination != nil {
+			return validateRouteRuleReferences(config, rule, store, discovery)
+		}
+	case *proxyconfig.EgressRule:
+		if rule.Destination != nil {
+			return validateEgressRuleReferences(config, rule, store)
+		}
+	case *proxyconfig.DestinationPolicy:
+		if rule.Destination != nil {
+			return validateDestinationPolicyReferences(config, rule, store)
+		}
+	}
+	return nil
+}
+
+// ValidateDeletion checks that removing a configuration object does not leave
+// other objects in the config store referring to it. Route rules for external
+// destinations depend on the egress rules whose domains, possibly wildcards,
+// match the destination.
+func ValidateDeletion(typ, name, namespace string, store IstioConfigStore, discovery ServiceDiscovery) error {
+	if typ != EgressRule.Type {
+		return nil
+	}
+
+	config, exists := store.Get(typ, name, namespace)
+	if !exists {
+		return nil
+	}
+	egress := config.Spec.(*proxyconfig.EgressRule)
+
+	// the destinations remain reachable through the domains, including the
+	// wildcard domains, of the other egress rules
+	var remaining []string
+	for key, other := range store.EgressRules() {
+		if key != config.Key() {
+			remaining = append(remaining, other.Destination.Service)
+		}
+	}
+
+	rules, err := store.List(RouteRule.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, rule := range rules {
+		destination := ResolveHostname(rule.ConfigMeta, rule.Spec.(*proxyconfig.RouteRule).Destination)
+		if !matchEgressDomain(egress.Destination.Service, destination) {
+			continue
+		}
+		if service, _ := discovery.GetService(destination); service != nil {
+			continue
+		}
+		reachable := false
+		for _, domain := range remaining {
+			reachable = reachable || matchEgressDomain(domain, destination)
+		}
+		if reachable {
+			continue
+		}
+		errs = multierror.Append(errs, fmt.Errorf("route rule %q refers to egress domain %s", rule.Key(), destination))
+	}
+
+	return errs
+}
+
+func validateRouteRuleReferences(config Config, rule *proxyconfig.RouteRule,
+	store IstioConfigStore, discovery ServiceDiscovery) error {
+	var errs error
+	destination := ResolveHostname(config.ConfigMeta, rule.Destination)
+	if err := validateDestinationExists(destination, nil, store, discovery); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	for _, dst := range rule.Route {
+		hostname := destination
+		if dst.Destination != nil {
+			hostname = ResolveHostname(config.ConfigMeta, dst.Destination)
+		}
+		if err := validateDestinationExists(hostname, dst.Labels, store, discovery); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	if mirror, _ := RouteMirror(config.ConfigMeta); mirror != nil {
+		hostname := ResolveHostname(config.ConfigMeta, mirror)
+		if err := validateDestinationExists(hostname, mirror.Labels, store, discovery); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	others, err := store.List(RouteRule.Type, NamespaceAll)
+	if err != nil {
+		return multierror.Append(errs, err)
+	}
+	for _, other := range others {
+		if other.Key() == config.Key() {
+			continue
+		}
+		otherRule := other.Spec.(*proxyconfig.RouteRule)
+		if otherRule.Precedence == rule.Precedence && equalMatch(otherRule.Match, rule.Match) &&
+			ResolveHostname(other.ConfigMeta, otherRule.Destination) == destination {
+			errs = multierror.Append(errs, fmt.Errorf("route rule %q has the same precedence %d and match "+
+				"conditions for destination %s", other.Key(), rule.Precedence, destination))
+		}
+	}
+
+	return errs
+}
+
+// equalMatch compares the match conditions, a missing condition matching all
+// requests like an empty one
+func equalMatch(a, b *proxyconfig.MatchCondition) bool {
+	if a == nil {
+		a = &proxyconfig.MatchCondition{}
+	}
+	if b == nil {
+		b = &proxyconfig.MatchCondition{}
+	}
+	return proto.Equal(a, b)
+}
+
+// validateDestinationExists checks that a hostname is either a known service
+// with instances matching the labels, or an external domain declared by an
+// egress rule
+func validateDestinationExists(hostname string, labels Labels, store IstioConfigStore,
+	discovery ServiceDiscovery) error {
+	service, err := discovery.GetService(hostname)
+	if err != nil {
+		return err
+	}
+
+	if service == nil {
+		for _, egress := range store.EgressRules() {
+			if matchEgressDomain(egress.Destination.Service, hostname) {
+				return nil
+			}
+		}
+		return fmt.Errorf("destination service %s does not exist", hostname)
+	}
+
+	if len(labels) == 0 || service.External() {
+		return nil
+	}
+
+	instances, err := discovery.Instances(hostname, service.Ports.GetNames(), LabelsCollection{labels})
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("no instances of destination service %s match labels %v", hostname, labels)
+	}
+	return nil
+}
+
+func validateEgressRuleReferences(config Config, rule *proxyconfig.EgressRule, store IstioConfigStore) error {
+	var errs error
+	for key, other := range store.EgressRules() {
+		if key != config.Key() && other.Destination.Service == rule.Destination.Service {
+			errs = multierror.Append(errs, fmt.Errorf("egress rule %q has the same domain %s",
+				key, rule.Destination.Service))
+		}
+	}
+	return errs
+}
+
+func validateDestinationPolicyReferences(config Config, policy *proxyconfig.DestinationPolicy,
+	store IstioConfigStore) error {
+	others, err := store.List(DestinationPolicy.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	destination := ResolveHostname(config.ConfigMeta, policy.Destination)
+	for _, other := range others {
+		if other.Key() == config.Key() {
+			continue
+		}
+		otherPolicy := other.Spec.(*proxyconfig.DestinationPolicy)
+		if ResolveHostname(other.ConfigMeta, otherPolicy.Destination) != destination ||
+			!Labels(policy.Destination.Labels).Equals(otherPolicy.Destination.Labels) {
+			continue
+		}
+		if !sameSource(config.ConfigMeta, policy.Source, other.ConfigMeta, otherPolicy.Source) {
+			continue
+		}
+
+		// Policy selects the policy with the smallest key out of the matching ones
+		if other.Key() < config.Key() {
+			errs = multierror.Append(errs, fmt.Errorf("destination policy is shadowed by %q", other.Key()))
+		} else {
+			errs = multierror.Append(errs, fmt.Errorf("destination policy shadows %q", other.Key()))
+		}
+	}
+
+	return errs
+}
+
+// sameSource checks that two source references select the same instances
+func sameSource(meta ConfigMeta, source *proxyconfig.IstioService,
+	otherMeta ConfigMeta, other *proxyconfig.IstioService) bool {
+	if source == nil || other == nil {
+		return source == nil && other == nil
+	}
+	return ResolveHostname(meta, source) == ResolveHostname(otherMeta, other) &&
+		Labels(source.Labels).Equals(other.Labels)
+}
+
+// matchEgressDomain checks that a hostname is covered by an egress rule domain,
+// following the wildcard semantics of ValidateEgressRuleDomain
+func matchEgressDomain(domain, hostname string) bool {
+	if domain == "*" {
+		return true
+	}
+	if strings.HasPrefix(domain, "*") {
+		return strings.HasSuffix(hostname, domain[1:]) && len(hostname) > len(domain)-1
+	}
+	return domain == hostname
+}
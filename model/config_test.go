@@ -671,3 +671,220 @@ func TestRejectConflictingEgressRules(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateReferences(t *testing.T) {
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	meta := func(typ, name string) model.ConfigMeta {
+		return model.ConfigMeta{
+			Type:      typ,
+			Name:      name,
+			Namespace: "default",
+			Domain:    "cluster.local",
+		}
+	}
+
+	existing := []model.Config{
+		{
+			ConfigMeta: meta(model.RouteRule.Type, "world-default"),
+			Spec: &proxyconfig.RouteRule{
+				Destination: &proxyconfig.IstioService{Name: "world"},
+				Precedence:  1,
+			},
+		},
+		{
+			ConfigMeta: meta(model.EgressRule.Type, "google"),
+			Spec: &proxyconfig.EgressRule{
+				Destination: &proxyconfig.IstioService{Service: "*.google.com"},
+				Ports:       []*proxyconfig.EgressRule_Port{{Port: 80, Protocol: "http"}},
+			},
+		},
+		{
+			ConfigMeta: meta(model.DestinationPolicy.Type, "world-v1"),
+			Spec: &proxyconfig.DestinationPolicy{
+				Destination: &proxyconfig.IstioService{
+					Name:   "world",
+					Labels: map[string]string{"version": "v1"},
+				},
+			},
+		},
+	}
+	for _, config := range existing {
+		if _, err := store.Create(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		in    model.Config
+		valid bool
+	}{
+		{
+			name: "route to known service and labels",
+			in: model.Config{
+				ConfigMeta: meta(model.RouteRule.Type, "world-split"),
+				Spec: &proxyconfig.RouteRule{
+					Destination: &proxyconfig.IstioService{Name: "world"},
+					Precedence:  2,
+					Route: []*proxyconfig.DestinationWeight{
+						{Labels: map[string]string{"version": "v0"}, Weight: 50},
+						{Labels: map[string]string{"version": "v1"}, Weight: 50},
+					},
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "update keeps precedence",
+			in: model.Config{
+				ConfigMeta: meta(model.RouteRule.Type, "world-default"),
+				Spec: &proxyconfig.RouteRule{
+					Destination: &proxyconfig.IstioService{Name: "world"},
+					Precedence:  1,
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "route to unknown service",
+			in: model.Config{
+				ConfigMeta: meta(model.RouteRule.Type, "unknown"),
+				Spec: &proxyconfig.RouteRule{
+					Destination: &proxyconfig.IstioService{Name: "unknown"},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "route to unknown labels",
+			in: model.Config{
+				ConfigMeta: meta(model.RouteRule.Type, "world-v3"),
+				Spec: &proxyconfig.RouteRule{
+					Destination: &proxyconfig.IstioService{Name: "world"},
+					Precedence:  3,
+					Route: []*proxyconfig.DestinationWeight{
+						{Labels: map[string]string{"version": "v3"}},
+					},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "route to egress domain",
+			in: model.Config{
+				ConfigMeta: meta(model.RouteRule.Type, "google"),
+				Spec: &proxyconfig.RouteRule{
+					Destination: &proxyconfig.IstioService{Service: "www.google.com"},
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "precedence tie",
+			in: model.Config{
+				ConfigMeta: meta(model.RouteRule.Type, "world-tie"),
+				Spec: &proxyconfig.RouteRule{
+					Destination: &proxyconfig.IstioService{Name: "world"},
+					Precedence:  1,
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "precedence tie with other match conditions",
+			in: model.Config{
+				ConfigMeta: meta(model.RouteRule.Type, "world-jason"),
+				Spec: &proxyconfig.RouteRule{
+					Destination: &proxyconfig.IstioService{Name: "world"},
+					Precedence:  1,
+					Match: &proxyconfig.MatchCondition{
+						Request: &proxyconfig.MatchRequest{
+							Headers: map[string]*proxyconfig.StringMatch{
+								"cookie": {MatchType: &proxyconfig.StringMatch_Regex{Regex: "^(.*?;)?(user=jason)(;.*)?$"}},
+							},
+						},
+					},
+				},
+			},
+			valid: true,
+		},
+		{
+			name: "duplicate egress domain",
+			in: model.Config{
+				ConfigMeta: meta(model.EgressRule.Type, "google-again"),
+				Spec: &proxyconfig.EgressRule{
+					Destination: &proxyconfig.IstioService{Service: "*.google.com"},
+					Ports:       []*proxyconfig.EgressRule_Port{{Port: 443, Protocol: "https"}},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "shadowed destination policy",
+			in: model.Config{
+				ConfigMeta: meta(model.DestinationPolicy.Type, "world-v1-again"),
+				Spec: &proxyconfig.DestinationPolicy{
+					Destination: &proxyconfig.IstioService{
+						Name:   "world",
+						Labels: map[string]string{"version": "v1"},
+					},
+				},
+			},
+			valid: false,
+		},
+		{
+			name: "destination policy for another source",
+			in: model.Config{
+				ConfigMeta: meta(model.DestinationPolicy.Type, "hello-world-v1"),
+				Spec: &proxyconfig.DestinationPolicy{
+					Source: &proxyconfig.IstioService{Name: "hello"},
+					Destination: &proxyconfig.IstioService{
+						Name:   "world",
+						Labels: map[string]string{"version": "v1"},
+					},
+				},
+			},
+			valid: true,
+		},
+	}
+
+	for _, c := range cases {
+		if err := model.ValidateReferences(c.in, store, mock.Discovery); (err == nil) != c.valid {
+			t.Errorf("ValidateReferences(%s) => got valid=%v, want valid=%v: %v", c.name, err == nil, c.valid, err)
+		}
+	}
+
+	if err := model.ValidateDeletion(model.EgressRule.Type, "google", "default", store, mock.Discovery); err != nil {
+		t.Errorf("ValidateDeletion() => unexpected error for unreferenced egress rule: %v", err)
+	}
+
+	route := model.Config{
+		ConfigMeta: meta(model.RouteRule.Type, "google"),
+		Spec: &proxyconfig.RouteRule{
+			Destination: &proxyconfig.IstioService{Service: "www.google.com"},
+		},
+	}
+	if _, err := store.Create(route); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.ValidateDeletion(model.EgressRule.Type, "google", "default", store, mock.Discovery); err == nil {
+		t.Error("ValidateDeletion() => expected error for referenced egress rule")
+	}
+	if err := model.ValidateDeletion(model.RouteRule.Type, "google", "default", store, mock.Discovery); err != nil {
+		t.Errorf("ValidateDeletion() => unexpected error for route rule: %v", err)
+	}
+
+	wildcard := model.Config{
+		ConfigMeta: meta(model.EgressRule.Type, "google-wildcard"),
+		Spec: &proxyconfig.EgressRule{
+			Destination: &proxyconfig.IstioService{Service: "*.google.com"},
+			Ports:       []*proxyconfig.EgressRule_Port{{Port: 80, Protocol: "http"}},
+		},
+	}
+	if _, err := store.Create(wildcard); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.ValidateDeletion(model.EgressRule.Type, "google", "default", store, mock.Discovery); err != nil {
+		t.Errorf("ValidateDeletion() => unexpected error for egress domain matched by a wildcard: %v", err)
+	}
+}
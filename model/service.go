@@ -26,6 +26,7 @@ import (
 	"bytes"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -60,6 +61,10 @@ type Service struct {
 
 	// LoadBalancingDisabled indicates that no load balancing should be done for this service.
 	LoadBalancingDisabled bool `json:"-"`
+
+	// AccessLogDisabled suppresses the proxy access logs for the requests to the service instances
+	// and for the TCP connections to the service address.
+	AccessLogDisabled bool `json:"-"`
 }
 
 // Port represents a network port where a service is listening for
@@ -379,6 +384,56 @@ func ServiceKey(hostname string, servicePorts PortList, labelsList LabelsCollect
 	return buffer.String()
 }
 
+// WeightedLabels pairs a label set with its share of the traffic
+type WeightedLabels struct {
+	Labels Labels
+	Weight int
+}
+
+// WeightedServiceKey generates a service key for a port and a collection of
+// labels, followed by the traffic weights of the labels in the same order
+// example: name.namespace|tcp|version=v1;version=v2|75;25
+func WeightedServiceKey(hostname string, port *Port, weights []WeightedLabels) string {
+	sorted := make([]WeightedLabels, len(weights))
+	copy(sorted, weights)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Labels.String() < sorted[j].Labels.String() })
+
+	labels := make(LabelsCollection, 0, len(sorted))
+	values := make([]string, 0, len(sorted))
+	for _, weight := range sorted {
+		labels = append(labels, weight.Labels)
+		values = append(values, strconv.Itoa(weight.Weight))
+	}
+
+	return ServiceKey(hostname, PortList{port}, labels) + "|" + strings.Join(values, ";")
+}
+
+// ParseServiceKeyWeights extracts the traffic weights of the labels from a
+// service key generated by WeightedServiceKey. It returns nil if the key
+// carries no weights.
+func ParseServiceKeyWeights(s string) []WeightedLabels {
+	parts := strings.Split(s, "|")
+	if len(parts) < 4 || len(parts[2]) == 0 {
+		return nil
+	}
+
+	tags := strings.Split(parts[2], ";")
+	values := strings.Split(parts[3], ";")
+	if len(tags) != len(values) {
+		return nil
+	}
+
+	out := make([]WeightedLabels, 0, len(tags))
+	for i, tag := range tags {
+		weight, err := strconv.Atoi(values[i])
+		if err != nil {
+			return nil
+		}
+		out = append(out, WeightedLabels{Labels: ParseLabelsString(tag), Weight: weight})
+	}
+	return out
+}
+
 // ParseServiceKey is the inverse of the Service.String() method
 func ParseServiceKey(s string) (hostname string, ports PortList, labels LabelsCollection) {
 	parts := strings.Split(s, "|")
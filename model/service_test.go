@@ -177,3 +177,34 @@ func TestGetByPort(t *testing.T) {
 		t.Errorf("GetByPort(88) => want none but got %v, %t", port, exists)
 	}
 }
+
+func TestWeightedServiceKey(t *testing.T) {
+	port := &Port{Name: "tcp"}
+	weights := []WeightedLabels{
+		{Labels: Labels{"version": "v2"}, Weight: 25},
+		{Labels: Labels{"version": "v1"}, Weight: 75},
+	}
+
+	key := WeightedServiceKey("svc.ns", port, weights)
+	if want := "svc.ns|tcp|version=v1;version=v2|75;25"; key != want {
+		t.Errorf("WeightedServiceKey() => got %q, want %q", key, want)
+	}
+
+	hostname, ports, labels := ParseServiceKey(key)
+	if hostname != "svc.ns" || len(ports) != 1 || ports[0].Name != "tcp" || len(labels) != 2 {
+		t.Errorf("ParseServiceKey(%q) => got %q, %v, %v", key, hostname, ports, labels)
+	}
+
+	parsed := ParseServiceKeyWeights(key)
+	if len(parsed) != 2 ||
+		!parsed[0].Labels.Equals(Labels{"version": "v1"}) || parsed[0].Weight != 75 ||
+		!parsed[1].Labels.Equals(Labels{"version": "v2"}) || parsed[1].Weight != 25 {
+		t.Errorf("ParseServiceKeyWeights(%q) => got %v", key, parsed)
+	}
+
+	for _, unweighted := range []string{"svc.ns", "svc.ns|tcp", "svc.ns|tcp|version=v1", "svc.ns|tcp|version=v1|x"} {
+		if parsed := ParseServiceKeyWeights(unweighted); parsed != nil {
+			t.Errorf("ParseServiceKeyWeights(%q) => got %v, want nil", unweighted, parsed)
+		}
+	}
+}
@@ -97,6 +97,19 @@ type ControllerOptions struct {
 	// potential races where registration completes and k8s apiserver
 	// invokes the webhook before the HTTP server is started.
 	RegistrationDelay time.Duration
+
+	// ValidateReferences enables the optional semantic validation
+	// phase which checks created, updated, and deleted configuration
+	// against the current config store and service registry.
+	ValidateReferences bool
+
+	// ConfigStore is the current view of the configuration used by
+	// the semantic validation.
+	ConfigStore model.IstioConfigStore
+
+	// ServiceDiscovery is the service registry used by the semantic
+	// validation.
+	ServiceDiscovery model.ServiceDiscovery
 }
 
 // AdmissionController implements the external admission webhook for validation of
@@ -260,6 +273,14 @@ func (ac *AdmissionController) register(client admissionClient.ExternalAdmission
 		resources = append(resources, crd.ResourceName(schema.Plural))
 	}
 
+	operations := []admissionregistrationv1alpha1.OperationType{
+		admissionregistrationv1alpha1.Create,
+		admissionregistrationv1alpha1.Update,
+	}
+	if ac.options.ValidateReferences {
+		operations = append(operations, admissionregistrationv1alpha1.Delete)
+	}
+
 	webhook := &admissionregistrationv1alpha1.ExternalAdmissionHookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: ac.options.ExternalAdmissionWebhookName,
@@ -268,10 +289,7 @@ func (ac *AdmissionController) register(client admissionClient.ExternalAdmission
 			{
 				Name: ac.options.ExternalAdmissionWebhookName,
 				Rules: []admissionregistrationv1alpha1.RuleWithOperations{{
-					Operations: []admissionregistrationv1alpha1.OperationType{
-						admissionregistrationv1alpha1.Create,
-						admissionregistrationv1alpha1.Update,
-					},
+					Operations: operations,
 					Rule: admissionregistrationv1alpha1.Rule{
 						APIGroups:   []string{model.IstioAPIGroup},
 						APIVersions: []string{model.IstioAPIVersion},
@@ -353,16 +371,18 @@ func watched(watchedNamespaces []string, namespace string) bool {
 	return false
 }
 
-func (ac *AdmissionController) admit(review *v1alpha1.AdmissionReview) *v1alpha1.AdmissionReviewStatus {
-	makeErrorStatus := func(reason string, args ...interface{}) *v1alpha1.AdmissionReviewStatus {
-		result := apierrors.NewBadRequest(fmt.Sprintf(reason, args...)).Status()
-		return &v1alpha1.AdmissionReviewStatus{
-			Result: &result,
-		}
+func makeErrorStatus(reason string, args ...interface{}) *v1alpha1.AdmissionReviewStatus {
+	result := apierrors.NewBadRequest(fmt.Sprintf(reason, args...)).Status()
+	return &v1alpha1.AdmissionReviewStatus{
+		Result: &result,
 	}
+}
 
+func (ac *AdmissionController) admit(review *v1alpha1.AdmissionReview) *v1alpha1.AdmissionReviewStatus {
 	switch review.Spec.Operation {
 	case admission.Create, admission.Update:
+	case admission.Delete:
+		return ac.admitDelete(review)
 	default:
 		glog.Warningf("Unsupported webhook operation %v", review.Spec.Operation)
 		return &v1alpha1.AdmissionReviewStatus{Allowed: true}
@@ -390,6 +410,37 @@ func (ac *AdmissionController) admit(review *v1alpha1.AdmissionReview) *v1alpha1
 	if err := schema.Validate(out.Spec); err != nil {
 		return makeErrorStatus("configuration is invalid: %v", err)
 	}
+	if err := model.ValidateAnnotations(*out); err != nil {
+		return makeErrorStatus("configuration is invalid: %v", err)
+	}
+
+	if ac.options.ValidateReferences {
+		if err := model.ValidateReferences(*out, ac.options.ConfigStore, ac.options.ServiceDiscovery); err != nil {
+			return makeErrorStatus("configuration is inconsistent: %v", err)
+		}
+	}
+
+	return &v1alpha1.AdmissionReviewStatus{Allowed: true}
+}
+
+// admitDelete checks that the deleted configuration is not referred to by
+// other configuration. Deletions are always allowed unless the semantic
+// validation is enabled.
+func (ac *AdmissionController) admitDelete(review *v1alpha1.AdmissionReview) *v1alpha1.AdmissionReviewStatus {
+	if !ac.options.ValidateReferences || !watched(ac.options.ValidateNamespaces, review.Spec.Namespace) {
+		return &v1alpha1.AdmissionReviewStatus{Allowed: true}
+	}
+
+	schema, exists := ac.options.Descriptor.GetByType(crd.CamelCaseToKabobCase(review.Spec.Kind.Kind))
+	if !exists {
+		glog.Warningf("Unrecognized type %v in deletion of %v", review.Spec.Kind.Kind, review.Spec.Name)
+		return &v1alpha1.AdmissionReviewStatus{Allowed: true}
+	}
+
+	if err := model.ValidateDeletion(schema.Type, review.Spec.Name, review.Spec.Namespace,
+		ac.options.ConfigStore, ac.options.ServiceDiscovery); err != nil {
+		return makeErrorStatus("deletion is inconsistent: %v", err)
+	}
 
 	return &v1alpha1.AdmissionReviewStatus{Allowed: true}
 }
@@ -37,7 +37,9 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 
+	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/memory"
 	"istio.io/pilot/model"
 	"istio.io/pilot/model/test"
 	"istio.io/pilot/platform/kube"
@@ -247,6 +249,132 @@ func TestAdmissionController(t *testing.T) {
 	}
 }
 
+func TestAdmissionControllerReferences(t *testing.T) {
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	egress := model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:      model.EgressRule.Type,
+			Name:      "google",
+			Namespace: watchedNamespace,
+		},
+		Spec: &proxyconfig.EgressRule{
+			Destination: &proxyconfig.IstioService{Service: "*.google.com"},
+			Ports:       []*proxyconfig.EgressRule_Port{{Port: 80, Protocol: "http"}},
+		},
+	}
+	if _, err := store.Create(egress); err != nil {
+		t.Fatal(err)
+	}
+
+	makeRule := func(name, destination string) []byte {
+		config := model.Config{
+			ConfigMeta: model.ConfigMeta{
+				Type:      model.RouteRule.Type,
+				Name:      name,
+				Namespace: watchedNamespace,
+			},
+			Spec: &proxyconfig.RouteRule{
+				Destination: &proxyconfig.IstioService{Service: destination},
+			},
+		}
+		obj, err := crd.ConvertConfig(model.RouteRule, config)
+		if err != nil {
+			t.Fatalf("ConvertConfig(%v) failed: %v", config.Name, err)
+		}
+		raw, err := json.Marshal(&obj)
+		if err != nil {
+			t.Fatalf("Marshal(%v) failed: %v", config.Name, err)
+		}
+		return raw
+	}
+
+	testAdmissionController, err := NewController(nil, ControllerOptions{
+		Descriptor:                   model.IstioConfigTypes,
+		ExternalAdmissionWebhookName: testAdmissionHookName,
+		ServiceName:                  testAdmissionServiceName,
+		ServiceNamespace:             "istio-system",
+		ValidateNamespaces:           []string{watchedNamespace},
+		DomainSuffix:                 testDomainSuffix,
+		ValidateReferences:           true,
+		ConfigStore:                  store,
+		ServiceDiscovery:             mock.Discovery,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cases := []struct {
+		name string
+		in   v1alpha1.AdmissionReviewSpec
+		want bool
+	}{
+		{
+			name: "known destination",
+			in: v1alpha1.AdmissionReviewSpec{
+				Object:    runtime.RawExtension{Raw: makeRule("world", mock.WorldService.Hostname)},
+				Operation: admission.Create,
+			},
+			want: true,
+		},
+		{
+			name: "egress destination",
+			in: v1alpha1.AdmissionReviewSpec{
+				Object:    runtime.RawExtension{Raw: makeRule("google", "www.google.com")},
+				Operation: admission.Create,
+			},
+			want: true,
+		},
+		{
+			name: "unknown destination",
+			in: v1alpha1.AdmissionReviewSpec{
+				Object:    runtime.RawExtension{Raw: makeRule("unknown", "unknown.default.svc.cluster.local")},
+				Operation: admission.Update,
+			},
+			want: false,
+		},
+		{
+			name: "delete unreferenced egress rule",
+			in: v1alpha1.AdmissionReviewSpec{
+				Kind:      metav1.GroupVersionKind{Kind: "EgressRule"},
+				Name:      "google",
+				Namespace: watchedNamespace,
+				Operation: admission.Delete,
+			},
+			want: true,
+		},
+	}
+
+	for _, c := range cases {
+		got := testAdmissionController.admit(&v1alpha1.AdmissionReview{Spec: c.in})
+		if got.Allowed != c.want {
+			t.Errorf("%v: AdmissionReviewStatus.Allowed is wrong : got %v want %v", c.name, got.Allowed, c.want)
+		}
+	}
+
+	rule := model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:      model.RouteRule.Type,
+			Name:      "google",
+			Namespace: watchedNamespace,
+		},
+		Spec: &proxyconfig.RouteRule{
+			Destination: &proxyconfig.IstioService{Service: "www.google.com"},
+		},
+	}
+	if _, err := store.Create(rule); err != nil {
+		t.Fatal(err)
+	}
+	got := testAdmissionController.admit(&v1alpha1.AdmissionReview{Spec: v1alpha1.AdmissionReviewSpec{
+		Kind:      metav1.GroupVersionKind{Kind: "EgressRule"},
+		Name:      "google",
+		Namespace: watchedNamespace,
+		Operation: admission.Delete,
+	}})
+	if got.Allowed {
+		t.Error("expected deletion of a referenced egress rule to be rejected")
+	}
+}
+
 func makeTestData(t *testing.T, valid bool) []byte {
 	review := v1alpha1.AdmissionReview{
 		Spec: v1alpha1.AdmissionReviewSpec{
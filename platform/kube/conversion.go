@@ -41,6 +41,10 @@ const (
 	// are allowed to run this service on the VMs
 	CanonicalServiceAccountsOnVMAnnotation = "alpha.istio.io/canonical-serviceaccounts"
 
+	// AccessLogAnnotation on a service set to "disable" suppresses the proxy access logs for the
+	// service
+	AccessLogAnnotation = "alpha.istio.io/access-log"
+
 	// IstioURIPrefix is the URI prefix in the Istio service account scheme
 	IstioURIPrefix = "spiffe"
 
@@ -122,6 +126,7 @@ func convertService(svc v1.Service, domainSuffix string) *model.Service {
 		ExternalName:          external,
 		ServiceAccounts:       serviceaccounts,
 		LoadBalancingDisabled: loadBalancingDisabled,
+		AccessLogDisabled:     svc.Annotations[AccessLogAnnotation] == "disable",
 	}
 }
 
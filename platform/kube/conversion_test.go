@@ -133,6 +133,14 @@ func TestServiceConversion(t *testing.T) {
 	if !reflect.DeepEqual(sa, expected) {
 		t.Errorf("Unexpected service accounts %v (expecting %v)", sa, expected)
 	}
+
+	if service.AccessLogDisabled {
+		t.Error("service access log should be enabled")
+	}
+	localSvc.Annotations[AccessLogAnnotation] = "disable"
+	if service = convertService(localSvc, domainSuffix); !service.AccessLogDisabled {
+		t.Error("service access log should be disabled")
+	}
 }
 
 func TestServiceConversionWithEmptyServiceAccountsAnnotation(t *testing.T) {
@@ -17,10 +17,15 @@ package proxy
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math/rand"
 	"reflect"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
 	"golang.org/x/time/rate"
 )
 
@@ -48,14 +53,23 @@ import (
 //
 // Whenever the run function returns an error, the agent assumes that the proxy
 // failed to start and attempts to restart the proxy several times with an
-// exponential back-off. The subsequent restart attempts may reuse the epoch
-// from the failed attempt. Retry budgets are allocated whenever the desired
-// configuration changes.
+// exponential back-off, capped and randomized by the retry policy. The
+// subsequent restart attempts may reuse the epoch from the failed attempt.
+// Retry budgets are allocated whenever the desired configuration changes. Once
+// the budget is exhausted, the agent either panics or keeps retrying at the
+// maximum interval while reporting itself unhealthy until the proxy recovers.
+// Before panicking, the agent reports the exhausted budget for the panic delay
+// so that the liveness probes observe it.
 //
 // Agent executes a single control loop that receives notifications about
 // scheduled configuration updates, exits from older proxy epochs, and retry
 // attempt timers. The call to schedule a configuration update will block until
 // the control loop is ready to accept and process the configuration update.
+//
+// When the agent terminates, it first asks the proxy to drain: the proxy fails
+// its health checks so that the load balancers stop sending it new
+// connections. The agent then waits up to the drain period for the active
+// connections to close before aborting all epochs.
 type Agent interface {
 	// ScheduleConfigUpdate sets the desired configuration for the proxy.  Agent
 	// compares the current active configuration to the desired state and
@@ -64,8 +78,51 @@ type Agent interface {
 	ScheduleConfigUpdate(config interface{})
 
 	// Run starts the agent control loop and awaits for a signal on the input
-	// channel to exit the loop.
+	// channel to exit the loop. Run returns once the proxy epochs are drained
+	// and aborted.
 	Run(ctx context.Context)
+
+	// Status returns a snapshot of the proxy epochs and the restart attempts.
+	// It is safe to call concurrently with the control loop.
+	Status() Status
+
+	// Ready returns nil if the proxy runs the desired configuration and the
+	// proxy reports itself ready, or an error describing why it is not.
+	Ready() error
+}
+
+// Status of the agent control loop
+type Status struct {
+	// Epochs lists the running proxy epochs in ascending order
+	Epochs []int `json:"epochs"`
+
+	// Restarts is the number of proxy starts after the initial one
+	Restarts int `json:"restarts"`
+
+	// Failures is the number of proxy epochs that exited with an error
+	Failures int `json:"failures"`
+
+	// LastError is the last error returned by a proxy epoch
+	LastError string `json:"lastError,omitempty"`
+
+	// Budget is the number of restart attempts left for the desired
+	// configuration
+	Budget int `json:"budget"`
+
+	// RestartScheduled is set while a restart attempt is pending
+	RestartScheduled bool `json:"restartScheduled"`
+
+	// Current is set if the latest epoch runs the desired configuration
+	Current bool `json:"current"`
+
+	// Exhausted is set once the retry budget is exhausted and the agent gives
+	// up on the desired configuration
+	Exhausted bool `json:"exhausted"`
+
+	// Unhealthy is set once the agent exhausts the retry budget and keeps
+	// retrying the desired configuration, until the desired configuration
+	// changes or the proxy reports itself ready on it
+	Unhealthy bool `json:"unhealthy"`
 }
 
 var (
@@ -75,6 +132,8 @@ var (
 	DefaultRetry = Retry{
 		MaxRetries:      10,
 		InitialInterval: 200 * time.Millisecond,
+		MaxInterval:     30 * time.Second,
+		Jitter:          0.2,
 	}
 )
 
@@ -84,15 +143,32 @@ const (
 	MaxAborts = 10
 )
 
+// drainPollInterval is the delay between two successive checks of the active
+// connections of a draining proxy
+var drainPollInterval = time.Second
+
+// readyPollInterval is the delay between two successive readiness checks of
+// the proxy after an epoch starts and while the agent keeps retrying past the
+// budget
+var readyPollInterval = time.Second
+
+// panicDelay is the duration the agent reports the exhausted budget on the
+// status endpoints before it panics
+var panicDelay = 10 * time.Second
+
 // NewAgent creates a new proxy agent for the proxy start-up and clean-up functions.
-func NewAgent(proxy Proxy, retry Retry) Agent {
+// The proxy is drained for up to the drain period on termination; a zero drain
+// period aborts the proxy immediately.
+func NewAgent(proxy Proxy, retry Retry, drainPeriod time.Duration) Agent {
 	return &agent{
-		proxy:    proxy,
-		retry:    retry,
-		epochs:   make(map[int]interface{}),
-		configCh: make(chan interface{}),
-		statusCh: make(chan exitStatus),
-		abortCh:  make(map[int]chan error),
+		proxy:       proxy,
+		retry:       retry,
+		drainPeriod: drainPeriod,
+		epochs:      make(map[int]interface{}),
+		configCh:    make(chan interface{}),
+		statusCh:    make(chan exitStatus),
+		abortCh:     make(map[int]chan error),
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
@@ -110,6 +186,61 @@ type Retry struct {
 	// InitialInterval is the delay between the first restart, from then on it is
 	// multiplied by a factor of 2 for each subsequent retry
 	InitialInterval time.Duration
+
+	// MaxInterval caps the delay between the restarts, uncapped if 0
+	MaxInterval time.Duration
+
+	// Jitter is the fraction in [0, 1) by which the delays are randomly
+	// reduced so that the proxies failing together do not retry together
+	Jitter float64
+
+	// KeepRetrying retries at the maximum interval once the budget is
+	// exhausted instead of panicking
+	KeepRetrying bool
+}
+
+// maxDelay is the largest representable delay
+const maxDelay time.Duration = 1<<63 - 1
+
+// delay returns the delay before the retry attempt, starting at 0, with the
+// jitter drawn from the random source
+func (retry Retry) delay(attempt int, random *rand.Rand) time.Duration {
+	delay := retry.InitialInterval
+	for i := 0; i < attempt; i++ {
+		if retry.MaxInterval > 0 && delay >= retry.MaxInterval {
+			break
+		}
+		if delay > maxDelay/2 {
+			delay = maxDelay
+			break
+		}
+		delay *= 2
+	}
+	if retry.MaxInterval > 0 && delay > retry.MaxInterval {
+		delay = retry.MaxInterval
+	}
+	if retry.Jitter > 0 {
+		delay -= time.Duration(random.Float64() * retry.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// ValidateRetry checks the intervals and the jitter of the retry policy
+func ValidateRetry(retry Retry) (errs error) {
+	if retry.MaxRetries < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("max retries %d must be non-negative", retry.MaxRetries))
+	}
+	if retry.InitialInterval <= 0 {
+		errs = multierror.Append(errs, fmt.Errorf("initial interval %v must be positive", retry.InitialInterval))
+	}
+	if retry.MaxInterval < 0 || (retry.MaxInterval > 0 && retry.MaxInterval < retry.InitialInterval) {
+		errs = multierror.Append(errs, fmt.Errorf("max interval %v must be 0 or at least the initial interval %v",
+			retry.MaxInterval, retry.InitialInterval))
+	}
+	if retry.Jitter < 0 || retry.Jitter >= 1 {
+		errs = multierror.Append(errs, fmt.Errorf("jitter %v must be in [0, 1)", retry.Jitter))
+	}
+	return
 }
 
 // Proxy defines command interface for a proxy
@@ -123,6 +254,18 @@ type Proxy interface {
 	// Panic command is invoked with the desired config when all retries to
 	// start the proxy fail just before the agent terminating
 	Panic(interface{})
+
+	// Drain command asks the running proxy to fail the health checks ahead of
+	// the termination
+	Drain() error
+
+	// Ready command returns nil if the running proxy has received its
+	// configuration and is ready to serve, or the reason it is not
+	Ready() error
+
+	// ActiveConnections command returns the number of the connections still
+	// open on the running proxy
+	ActiveConnections() (int, error)
 }
 
 type agent struct {
@@ -132,6 +275,9 @@ type agent struct {
 	// retry configuration
 	retry Retry
 
+	// maximum duration of the drain on termination
+	drainPeriod time.Duration
+
 	// desired configuration state
 	desiredConfig interface{}
 
@@ -149,6 +295,27 @@ type agent struct {
 
 	// channel for aborting running instances
 	abortCh map[int]chan error
+
+	// number of started epochs and of epoch failures
+	starts   int
+	failures int
+
+	// last epoch error
+	lastErr error
+
+	// set once the retry budget is exhausted until the desired config changes
+	// or the proxy recovers
+	unhealthy bool
+
+	// set once an epoch starts until the proxy reports itself ready
+	reloading bool
+
+	// random source of the retry jitter, owned by the control loop
+	random *rand.Rand
+
+	// status snapshot published by the control loop
+	statusMutex sync.RWMutex
+	status      Status
 }
 
 type exitStatus struct {
@@ -160,6 +327,61 @@ func (a *agent) ScheduleConfigUpdate(config interface{}) {
 	a.configCh <- config
 }
 
+func (a *agent) Status() Status {
+	a.statusMutex.RLock()
+	defer a.statusMutex.RUnlock()
+	return a.status
+}
+
+func (a *agent) Ready() error {
+	status := a.Status()
+	switch {
+	case status.Exhausted:
+		return errors.New("retry budget exhausted")
+	case status.Unhealthy:
+		return errors.New("retry budget exhausted, retrying the desired configuration")
+	case len(status.Epochs) == 0:
+		return errors.New("proxy is not running")
+	case !status.Current || status.RestartScheduled:
+		return errors.New("proxy is not running the desired configuration")
+	}
+	if err := a.proxy.Ready(); err != nil {
+		return fmt.Errorf("proxy is not ready: %v", err)
+	}
+	return nil
+}
+
+// publishStatus updates the status snapshot from the control loop state
+func (a *agent) publishStatus(exhausted bool) {
+	epochs := make([]int, 0, len(a.epochs))
+	for epoch := range a.epochs {
+		epochs = append(epochs, epoch)
+	}
+	sort.Ints(epochs)
+
+	status := Status{
+		Epochs:           epochs,
+		Failures:         a.failures,
+		Budget:           a.retry.budget,
+		RestartScheduled: a.retry.restart != nil,
+		Current:          a.desiredConfig != nil && reflect.DeepEqual(a.desiredConfig, a.epochs[a.latestEpoch()]),
+		Exhausted:        exhausted,
+		Unhealthy:        a.unhealthy,
+	}
+	if a.starts > 1 {
+		status.Restarts = a.starts - 1
+	}
+	if a.lastErr != nil {
+		status.LastError = a.lastErr.Error()
+	}
+
+	a.statusMutex.Lock()
+	a.status = status
+	a.statusMutex.Unlock()
+
+	retryBudget.Set(float64(status.Budget))
+}
+
 func (a *agent) Run(ctx context.Context) {
 	glog.V(2).Info("Starting proxy agent")
 
@@ -175,9 +397,11 @@ func (a *agent) Run(ctx context.Context) {
 		}
 
 		// maximum duration or duration till next restart
-		var delay time.Duration = 1<<63 - 1
+		var delay = maxDelay
 		if a.retry.restart != nil {
 			delay = time.Until(*a.retry.restart)
+		} else if a.unhealthy || a.reloading {
+			delay = readyPollInterval
 		}
 
 		select {
@@ -188,7 +412,9 @@ func (a *agent) Run(ctx context.Context) {
 
 				// reset retry budget if and only if the desired config changes
 				a.retry.budget = a.retry.MaxRetries
+				a.unhealthy = false
 				a.reconcile()
+				a.publishStatus(false)
 			}
 
 		case status := <-a.statusCh:
@@ -200,8 +426,12 @@ func (a *agent) Run(ctx context.Context) {
 
 			if status.err == errAbort {
 				glog.V(2).Infof("Epoch %d aborted", status.epoch)
+				epochsAborted.Inc()
 			} else if status.err != nil {
 				glog.Warningf("Epoch %d terminated with an error: %v", status.epoch, status.err)
+				epochsFailed.Inc()
+				a.failures++
+				a.lastErr = status.err
 
 				// NOTE: due to Envoy hot restart race conditions, an error from the
 				// process requires aggressive non-graceful restarts by killing all
@@ -221,24 +451,44 @@ func (a *agent) Run(ctx context.Context) {
 			if status.err != nil {
 				// skip retrying twice by checking retry restart delay
 				if a.retry.restart == nil {
-					if a.retry.budget > 0 {
-						delayDuration := a.retry.InitialInterval * (1 << uint(a.retry.MaxRetries-a.retry.budget))
-						restart := time.Now().Add(delayDuration)
-						a.retry.restart = &restart
-						a.retry.budget = a.retry.budget - 1
-						glog.V(2).Infof("Epoch %d: set retry delay to %v, budget to %d", status.epoch, delayDuration, a.retry.budget)
-					} else {
+					if a.retry.budget == 0 && !a.retry.KeepRetrying {
 						glog.Error("Permanent error: budget exhausted trying to fulfill the desired configuration")
+						a.publishStatus(true)
+
+						// let the liveness probes observe the exhausted budget
+						select {
+						case <-time.After(panicDelay):
+						case <-ctx.Done():
+							a.terminate()
+							return
+						}
 						a.proxy.Panic(a.desiredConfig)
 						return
 					}
+					if a.retry.budget == 0 {
+						glog.Errorf("Budget exhausted trying to fulfill the desired configuration, retrying")
+						a.unhealthy = true
+					}
+					delayDuration := a.retry.delay(a.retry.MaxRetries-a.retry.budget, a.random)
+					restart := time.Now().Add(delayDuration)
+					a.retry.restart = &restart
+					if a.retry.budget > 0 {
+						a.retry.budget = a.retry.budget - 1
+					}
+					glog.V(2).Infof("Epoch %d: set retry delay to %v, budget to %d", status.epoch, delayDuration, a.retry.budget)
 				} else {
 					glog.V(2).Infof("Epoch %d: restart already scheduled", status.epoch)
 				}
 			}
+			a.publishStatus(false)
 
 		case <-time.After(delay):
-			a.reconcile()
+			if a.retry.restart == nil && (a.unhealthy || a.reloading) {
+				a.checkReady()
+			} else {
+				a.reconcile()
+			}
+			a.publishStatus(false)
 
 		case _, more := <-ctx.Done():
 			if !more {
@@ -251,9 +501,55 @@ func (a *agent) Run(ctx context.Context) {
 
 func (a *agent) terminate() {
 	glog.V(2).Info("Agent terminating")
+	a.drain()
 	a.abortAll()
 }
 
+// drain asks the proxy to drain and waits until the active connections close,
+// all epochs exit, or the drain period elapses
+func (a *agent) drain() {
+	if a.drainPeriod <= 0 || len(a.epochs) == 0 {
+		return
+	}
+
+	glog.V(2).Infof("Draining proxy for up to %v", a.drainPeriod)
+	if err := a.proxy.Drain(); err != nil {
+		glog.Warningf("Failed to drain proxy: %v", err)
+		return
+	}
+
+	deadline := time.After(a.drainPeriod)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case status := <-a.statusCh:
+			glog.V(2).Infof("Epoch %d exited while draining: %v", status.epoch, status.err)
+			delete(a.epochs, status.epoch)
+			delete(a.abortCh, status.epoch)
+			a.proxy.Cleanup(status.epoch)
+			if len(a.epochs) == 0 {
+				return
+			}
+
+		case <-ticker.C:
+			active, err := a.proxy.ActiveConnections()
+			if err != nil {
+				glog.Warningf("Failed to count active connections: %v", err)
+			} else if active == 0 {
+				glog.V(2).Info("Proxy drained")
+				return
+			} else {
+				glog.V(2).Infof("Draining %d active connections", active)
+			}
+
+		case <-deadline:
+			glog.Warningf("Drain period %v elapsed, aborting remaining connections", a.drainPeriod)
+			return
+		}
+	}
+}
+
 func (a *agent) reconcile() {
 	// cancel any scheduled restart
 	a.retry.restart = nil
@@ -273,9 +569,34 @@ func (a *agent) reconcile() {
 	a.epochs[epoch] = a.desiredConfig
 	a.abortCh[epoch] = abortCh
 	a.currentConfig = a.desiredConfig
+	a.starts++
+	epochsStarted.Inc()
+	a.reloading = true
 	go a.waitForExit(a.desiredConfig, epoch, abortCh)
 }
 
+// checkReady records the reload and clears the unhealthy state once the
+// latest epoch runs the desired configuration and the proxy reports itself
+// ready
+func (a *agent) checkReady() {
+	if len(a.epochs) == 0 || !reflect.DeepEqual(a.desiredConfig, a.currentConfig) {
+		return
+	}
+	if err := a.proxy.Ready(); err != nil {
+		glog.V(2).Infof("Proxy is not ready yet: %v", err)
+		return
+	}
+	if a.reloading {
+		glog.V(2).Infof("Epoch %d is ready", a.latestEpoch())
+		recordReload()
+		a.reloading = false
+	}
+	if a.unhealthy {
+		glog.Infof("Proxy recovered on the desired configuration")
+		a.unhealthy = false
+	}
+}
+
 // waitForExit runs the start-up command as a go routine and waits for it to finish
 func (a *agent) waitForExit(config interface{}, epoch int, abortCh <-chan error) {
 	glog.V(2).Infof("Epoch %d starting", epoch)
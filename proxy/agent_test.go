@@ -18,6 +18,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -52,6 +55,33 @@ func (tp TestProxy) Panic(config interface{}) {
 	}
 }
 
+func (tp TestProxy) Drain() error {
+	return nil
+}
+
+func (tp TestProxy) ActiveConnections() (int, error) {
+	return 0, nil
+}
+
+func (tp TestProxy) Ready() error {
+	return nil
+}
+
+// DrainTestProxy overrides the drain commands of the test proxy
+type DrainTestProxy struct {
+	TestProxy
+	drain  func() error
+	active func() (int, error)
+}
+
+func (tp DrainTestProxy) Drain() error {
+	return tp.drain()
+}
+
+func (tp DrainTestProxy) ActiveConnections() (int, error) {
+	return tp.active()
+}
+
 // TestStartStop tests basic start, cleanup sequence
 func TestStartStop(t *testing.T) {
 	current := -1
@@ -79,7 +109,7 @@ func TestStartStop(t *testing.T) {
 		}
 		cancel()
 	}
-	a := NewAgent(TestProxy{start, cleanup, nil}, testRetry)
+	a := NewAgent(TestProxy{start, cleanup, nil}, testRetry, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate(desired)
 	<-ctx.Done()
@@ -97,7 +127,7 @@ func TestApplyTwice(t *testing.T) {
 		return nil
 	}
 	cleanup := func(epoch int) {}
-	a := NewAgent(TestProxy{start, cleanup, nil}, testRetry)
+	a := NewAgent(TestProxy{start, cleanup, nil}, testRetry, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate(desired)
 	a.ScheduleConfigUpdate(desired)
@@ -135,7 +165,7 @@ func TestApplyThrice(t *testing.T) {
 	}
 	retry := testRetry
 	retry.MaxRetries = 0
-	a = NewAgent(TestProxy{start, cleanup, nil}, retry)
+	a = NewAgent(TestProxy{start, cleanup, nil}, retry, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate(good)
 	a.ScheduleConfigUpdate(bad)
@@ -182,7 +212,7 @@ func TestAbort(t *testing.T) {
 	}
 	retry := testRetry
 	retry.InitialInterval = 10 * time.Second
-	a := NewAgent(TestProxy{start, cleanup, nil}, retry)
+	a := NewAgent(TestProxy{start, cleanup, nil}, retry, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate(good1)
 	a.ScheduleConfigUpdate(good2)
@@ -211,7 +241,7 @@ func TestStartFail(t *testing.T) {
 		return nil
 	}
 	cleanup := func(epoch int) {}
-	a := NewAgent(TestProxy{start, cleanup, nil}, testRetry)
+	a := NewAgent(TestProxy{start, cleanup, nil}, testRetry, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate("test")
 	<-ctx.Done()
@@ -241,9 +271,17 @@ func TestExceedBudget(t *testing.T) {
 			cancel()
 		}
 	}
+	panicDelay = time.Millisecond
+	var a Agent
+	panicked := func(_ interface{}) {
+		if !a.Status().Exhausted {
+			t.Error("Status() => got not exhausted before the panic")
+		}
+		cancel()
+	}
 	retryDelay := testRetry
 	retryDelay.MaxRetries = 1
-	a := NewAgent(TestProxy{start, cleanup, func(_ interface{}) { cancel() }}, retryDelay)
+	a = NewAgent(TestProxy{start, cleanup, panicked}, retryDelay, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate("test")
 	<-ctx.Done()
@@ -296,7 +334,7 @@ func TestStartTwiceStop(t *testing.T) {
 			cancel()
 		}
 	}
-	a := NewAgent(TestProxy{start, cleanup, nil}, testRetry)
+	a := NewAgent(TestProxy{start, cleanup, nil}, testRetry, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate(desired0)
 	a.ScheduleConfigUpdate(desired1)
@@ -320,7 +358,7 @@ func TestRecovery(t *testing.T) {
 		<-ctx.Done()
 		return nil
 	}
-	a := NewAgent(TestProxy{start, func(_ int) {}, nil}, testRetry)
+	a := NewAgent(TestProxy{start, func(_ int) {}, nil}, testRetry, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate(desired)
 
@@ -357,7 +395,7 @@ func TestCascadingAbort(t *testing.T) {
 	}
 	retry := testRetry
 	retry.InitialInterval = 1 * time.Second
-	a := NewAgent(TestProxy{start, func(_ int) {}, nil}, retry)
+	a := NewAgent(TestProxy{start, func(_ int) {}, nil}, retry, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate(0)
 	a.ScheduleConfigUpdate(1)
@@ -392,7 +430,7 @@ func TestLockup(t *testing.T) {
 		}
 		return nil
 	}
-	a := NewAgent(TestProxy{start, func(_ int) {}, nil}, testRetry)
+	a := NewAgent(TestProxy{start, func(_ int) {}, nil}, testRetry, 0)
 	go a.Run(ctx)
 	a.ScheduleConfigUpdate(0)
 	a.ScheduleConfigUpdate(1)
@@ -402,3 +440,285 @@ func TestLockup(t *testing.T) {
 		t.Error("liveness check failed")
 	}
 }
+
+// TestDrain checks that the agent drains the proxy before aborting it
+func TestDrain(t *testing.T) {
+	drainPollInterval = time.Millisecond
+	cases := []struct {
+		name        string
+		drainPeriod time.Duration
+		drainErr    error
+		connections []int
+		drained     bool
+		polls       int
+	}{
+		{name: "connections close", drainPeriod: time.Minute, connections: []int{3, 1, 0}, drained: true, polls: 3},
+		{name: "drain period elapses", drainPeriod: 50 * time.Millisecond, connections: []int{1}, drained: true},
+		{name: "drain fails", drainPeriod: time.Minute, drainErr: errors.New("unreachable"), drained: true},
+		{name: "drain disabled", drainPeriod: 0, drained: false},
+	}
+
+	for _, c := range cases {
+		ctx, cancel := context.WithCancel(context.Background())
+		started := make(chan struct{})
+		aborted := make(chan struct{})
+		drained := false
+		polls := 0
+		start := func(_ interface{}, _ int, abort <-chan error) error {
+			close(started)
+			err := <-abort
+			close(aborted)
+			return err
+		}
+		proxy := DrainTestProxy{
+			TestProxy: TestProxy{start, nil, nil},
+			drain: func() error {
+				drained = true
+				return c.drainErr
+			},
+			active: func() (int, error) {
+				active := c.connections[len(c.connections)-1]
+				if polls < len(c.connections) {
+					active = c.connections[polls]
+				}
+				polls++
+				return active, nil
+			},
+		}
+		a := NewAgent(proxy, testRetry, c.drainPeriod)
+		go a.Run(ctx)
+		a.ScheduleConfigUpdate("config")
+		<-started
+		cancel()
+
+		select {
+		case <-aborted:
+		case <-time.After(time.Second):
+			t.Errorf("%s: proxy not aborted after the drain", c.name)
+			continue
+		}
+		if drained != c.drained {
+			t.Errorf("%s: got drained %t, want %t", c.name, drained, c.drained)
+		}
+		if c.polls > 0 && polls != c.polls {
+			t.Errorf("%s: got %d polls of the active connections, want %d", c.name, polls, c.polls)
+		}
+	}
+}
+
+// TestStatus checks the status snapshot across failures and restarts
+func TestStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	running := make(chan struct{})
+	start := func(config interface{}, _ int, _ <-chan error) error {
+		if config == "bad" {
+			return errors.New("bad config")
+		}
+		running <- struct{}{}
+		<-ctx.Done()
+		return nil
+	}
+	a := NewAgent(TestProxy{start, nil, nil}, Retry{InitialInterval: time.Hour, MaxRetries: 3}, 0)
+	go a.Run(ctx)
+
+	if err := a.Ready(); err == nil {
+		t.Error("Ready() => expected an error before the proxy starts")
+	}
+
+	a.ScheduleConfigUpdate("good")
+	<-running
+	a.ScheduleConfigUpdate("bad")
+
+	// the status is published after the failed epoch is processed
+	var status Status
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if status = a.Status(); status.Failures > 0 {
+			break
+		}
+	}
+	want := Status{
+		Epochs:           []int{0},
+		Restarts:         1,
+		Failures:         1,
+		LastError:        "bad config",
+		Budget:           2,
+		RestartScheduled: true,
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("Status() => got %#v, want %#v", status, want)
+	}
+	if err := a.Ready(); err == nil {
+		t.Error("Ready() => expected an error while the desired configuration is not running")
+	}
+}
+
+// TestRetryDelay checks the exponential back-off, its cap, and its jitter
+func TestRetryDelay(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	retry := Retry{InitialInterval: time.Second, MaxInterval: 10 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}
+	for attempt, delay := range want {
+		if got := retry.delay(attempt, random); got != delay {
+			t.Errorf("delay(%d) => got %v, want %v", attempt, got, delay)
+		}
+	}
+	if got := retry.delay(100, random); got != retry.MaxInterval {
+		t.Errorf("delay(100) => got %v, want the max interval", got)
+	}
+
+	uncapped := Retry{InitialInterval: time.Second}
+	if got := uncapped.delay(100, random); got != maxDelay {
+		t.Errorf("delay(100) => got %v without a max interval, want %v", got, maxDelay)
+	}
+
+	retry.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := retry.delay(10, random); got < retry.MaxInterval/2 || got > retry.MaxInterval {
+			t.Fatalf("delay(10) => got %v with jitter, want in [%v, %v]", got, retry.MaxInterval/2, retry.MaxInterval)
+		}
+	}
+}
+
+func TestValidateRetry(t *testing.T) {
+	if err := ValidateRetry(DefaultRetry); err != nil {
+		t.Errorf("ValidateRetry(DefaultRetry) => unexpected error %v", err)
+	}
+	invalid := []Retry{
+		{MaxRetries: -1, InitialInterval: time.Second},
+		{InitialInterval: 0},
+		{InitialInterval: time.Second, MaxInterval: time.Millisecond},
+		{InitialInterval: time.Second, Jitter: 1},
+	}
+	for _, retry := range invalid {
+		if err := ValidateRetry(retry); err == nil {
+			t.Errorf("ValidateRetry(%#v) => expected an error", retry)
+		}
+	}
+}
+
+// TestKeepRetrying retries past the budget without panicking
+func TestKeepRetrying(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	attempts := make(chan int, 10)
+	start := func(_ interface{}, epoch int, _ <-chan error) error {
+		select {
+		case attempts <- epoch:
+		default:
+		}
+		return errors.New("bad config")
+	}
+	panicked := func(_ interface{}) {
+		t.Error("Panic() => unexpected call with keep retrying")
+	}
+	retry := Retry{
+		MaxRetries:      1,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     10 * time.Millisecond,
+		KeepRetrying:    true,
+	}
+	a := NewAgent(TestProxy{start, nil, panicked}, retry, 0)
+	go a.Run(ctx)
+	a.ScheduleConfigUpdate("bad")
+
+	// the initial start, the budgeted retry, and the retries at the max
+	// interval
+	for i := 0; i < 4; i++ {
+		select {
+		case <-attempts:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d start attempts, want 4", i)
+		}
+	}
+
+	unhealthy := false
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && !unhealthy; time.Sleep(time.Millisecond) {
+		status := a.Status()
+		unhealthy = status.Unhealthy
+		if status.Exhausted {
+			t.Fatal("Status() => got exhausted with keep retrying")
+		}
+	}
+	if !unhealthy {
+		t.Error("Status() => got healthy after exhausting the retry budget")
+	}
+}
+
+// TestKeepRetryingRecovery clears the unhealthy state once the proxy recovers
+// on the same configuration after the budget is exhausted
+func TestKeepRetryingRecovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var attempts int32
+	start := func(_ interface{}, _ int, _ <-chan error) error {
+		if atomic.AddInt32(&attempts, 1) <= 5 {
+			return errors.New("upstream unavailable")
+		}
+		<-ctx.Done()
+		return nil
+	}
+	retry := Retry{
+		MaxRetries:      1,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     10 * time.Millisecond,
+		KeepRetrying:    true,
+	}
+	a := NewAgent(TestProxy{start, nil, nil}, retry, 0)
+	go a.Run(ctx)
+	a.ScheduleConfigUpdate("config")
+
+	unhealthy, recovered := false, false
+	deadline := time.Now().Add(5 * time.Second)
+	for ; time.Now().Before(deadline) && !recovered; time.Sleep(time.Millisecond) {
+		status := a.Status()
+		unhealthy = unhealthy || status.Unhealthy
+		recovered = unhealthy && !status.Unhealthy && status.Current
+	}
+	if !unhealthy {
+		t.Fatal("Status() => got healthy after exhausting the retry budget")
+	}
+	if !recovered {
+		t.Fatal("Status() => got unhealthy after the proxy recovered")
+	}
+	if err := a.Ready(); err != nil {
+		t.Errorf("Ready() => got %v after the proxy recovered", err)
+	}
+}
+
+// TestRecordReload records the reloads once the started epochs are ready and
+// not when they fail
+func TestRecordReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := func(config interface{}, _ int, _ <-chan error) error {
+		if config == "bad" {
+			return errors.New("bad config")
+		}
+		<-ctx.Done()
+		return nil
+	}
+	a := NewAgent(TestProxy{start, nil, nil}, Retry{InitialInterval: time.Hour, MaxRetries: 3}, 0)
+	go a.Run(ctx)
+	atomic.StoreInt64(&lastReload, 0)
+
+	a.ScheduleConfigUpdate("bad")
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if a.Status().Failures > 0 {
+			break
+		}
+	}
+	if reload := atomic.LoadInt64(&lastReload); reload != 0 {
+		t.Errorf("recorded a reload at %d for a failed epoch", reload)
+	}
+
+	a.ScheduleConfigUpdate("good")
+	reloaded := false
+	deadline := time.Now().Add(5 * time.Second)
+	for ; time.Now().Before(deadline) && !reloaded; time.Sleep(time.Millisecond) {
+		reloaded = atomic.LoadInt64(&lastReload) != 0
+	}
+	if !reloaded {
+		t.Error("no reload recorded once the epoch is ready")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+// ConfigRenderer builds the backend specific configuration scheduled on the
+// agent by the watcher
+type ConfigRenderer interface {
+	// Render builds the configuration for the proxy config and the hash of
+	// the monitored certificates. The watcher keeps the current
+	// configuration if rendering fails.
+	Render(config proxyconfig.ProxyConfig, certHash []byte) (interface{}, error)
+}
+
+// Backend is a proxy implementation managed by the agent
+type Backend struct {
+	// Proxy commands run by the agent
+	Proxy Proxy
+
+	// Renderer of the proxy configuration on the reloads
+	Renderer ConfigRenderer
+
+	// Directories monitored in addition to the certificates, whose changes
+	// trigger reloads
+	Directories []string
+
+	// Monitor runs until the context is cancelled and calls reload on the
+	// backend specific changes requiring a reload, optional
+	Monitor func(ctx context.Context, reload func())
+}
+
+// BackendOptions are the proxy independent inputs of the backends
+type BackendOptions struct {
+	// Config is the proxy config at the agent startup
+	Config proxyconfig.ProxyConfig
+
+	// Node is the proxy node identity
+	Node Node
+
+	// PilotSAN lists the subject alternate names of the discovery service
+	// if the control plane uses mutual TLS
+	PilotSAN []string
+}
+
+// BackendFactory creates a proxy backend
+type BackendFactory func(BackendOptions) (Backend, error)
+
+var (
+	backendsMutex sync.Mutex
+	backends      = make(map[string]BackendFactory)
+)
+
+// RegisterBackend makes the proxy backend available by the name. It panics
+// if a backend is registered twice under the same name.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+	if _, exists := backends[name]; exists {
+		panic(fmt.Sprintf("proxy backend %q is already registered", name))
+	}
+	backends[name] = factory
+}
+
+// Backends returns the sorted names of the registered proxy backends
+func Backends() []string {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBackend creates the proxy backend registered under the name
+func NewBackend(name string, options BackendOptions) (Backend, error) {
+	backendsMutex.Lock()
+	factory, exists := backends[name]
+	backendsMutex.Unlock()
+	if !exists {
+		return Backend{}, fmt.Errorf("unknown proxy backend %q, options are %v", name, Backends())
+	}
+
+	backend, err := factory(options)
+	if err != nil {
+		return Backend{}, err
+	}
+	if backend.Proxy == nil || backend.Renderer == nil {
+		return Backend{}, fmt.Errorf("proxy backend %q is missing the proxy or the renderer", name)
+	}
+	return backend, nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ca provisions the workload certificates of the proxy. The agent
+// generates the workload key and the certificate signing request, obtains the
+// certificate from a CA, and rotates the certificate before its expiry.
+package ca
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/proxy"
+)
+
+// CA signs the certificate signing requests of the workloads
+type CA interface {
+	// Sign signs the PEM encoded certificate signing request for the
+	// requested validity and returns the PEM encoded certificate chain
+	Sign(csr []byte, ttl time.Duration) ([]byte, error)
+
+	// RootCert returns the PEM encoded root certificate of the CA
+	RootCert() ([]byte, error)
+}
+
+// Options of the certificate provisioning
+type Options struct {
+	// Identity is the URI subject alternate name of the workload (e.g.
+	// spiffe://cluster.local/ns/default/sa/default)
+	Identity string
+
+	// Directory receives the key, the certificate chain, and the root
+	// certificate
+	Directory string
+
+	// TTL is the requested validity of the certificates
+	TTL time.Duration
+
+	// RotationRatio is the fraction of the certificate validity after which
+	// the certificate is rotated
+	RotationRatio float64
+
+	// RetryInterval is the delay between the provisioning attempts after a
+	// failure
+	RetryInterval time.Duration
+}
+
+// DefaultOptions writes the certificates to the mutual TLS certificate path
+// and rotates them halfway through their validity
+func DefaultOptions() Options {
+	return Options{
+		Directory:     proxy.AuthCertsPath,
+		TTL:           24 * time.Hour,
+		RotationRatio: 0.5,
+		RetryInterval: 30 * time.Second,
+	}
+}
+
+// ValidateOptions checks the identity, the validity, and the rotation ratio
+func ValidateOptions(options Options) (errs error) {
+	if options.Identity == "" {
+		errs = multierror.Append(errs, errors.New("workload identity is required"))
+	}
+	if options.TTL <= 0 {
+		errs = multierror.Append(errs, fmt.Errorf("certificate TTL %v must be positive", options.TTL))
+	}
+	if options.RotationRatio <= 0 || options.RotationRatio >= 1 {
+		errs = multierror.Append(errs, fmt.Errorf("rotation ratio %v must be in (0, 1)", options.RotationRatio))
+	}
+	if options.RetryInterval <= 0 {
+		errs = multierror.Append(errs, fmt.Errorf("retry interval %v must be positive", options.RetryInterval))
+	}
+	return
+}
+
+const (
+	// keySize is the size of the generated RSA workload keys
+	keySize = 2048
+
+	// file permissions of the written key and certificates
+	keyFileMode  = 0600
+	certFileMode = 0644
+)
+
+// oidSubjectAltName is the object identifier of the subject alternate name
+// extension
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// Provisioner obtains and rotates the workload certificates
+type Provisioner struct {
+	ca      CA
+	options Options
+}
+
+// NewProvisioner creates a provisioner of the workload certificates signed
+// by the CA
+func NewProvisioner(ca CA, options Options) *Provisioner {
+	return &Provisioner{ca: ca, options: options}
+}
+
+// Provision generates a key and a certificate signing request, obtains the
+// signed certificate from the CA, and writes the key, the certificate chain,
+// and the root certificate. It returns the time of the next rotation.
+func (p *Provisioner) Provision() (time.Time, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return time.Time{}, multierror.Prefix(err, "failed to generate key:")
+	}
+
+	san, err := buildSubjectAltNameExtension(p.options.Identity)
+	if err != nil {
+		return time.Time{}, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		ExtraExtensions: []pkix.Extension{san},
+	}, key)
+	if err != nil {
+		return time.Time{}, multierror.Prefix(err, "failed to create certificate signing request:")
+	}
+
+	chain, err := p.ca.Sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), p.options.TTL)
+	if err != nil {
+		return time.Time{}, multierror.Prefix(err, "failed to sign certificate:")
+	}
+	root, err := p.ca.RootCert()
+	if err != nil {
+		return time.Time{}, multierror.Prefix(err, "failed to get root certificate:")
+	}
+
+	block, _ := pem.Decode(chain)
+	if block == nil {
+		return time.Time{}, errors.New("signed certificate chain is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, multierror.Prefix(err, "invalid signed certificate:")
+	}
+
+	// the root certificate is written first so that the chain is trusted
+	// once it is replaced
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := writeFile(path.Join(p.options.Directory, proxy.RootCertFilename), root, certFileMode); err != nil {
+		return time.Time{}, err
+	}
+	if err := writeFile(path.Join(p.options.Directory, proxy.KeyFilename), keyPEM, keyFileMode); err != nil {
+		return time.Time{}, err
+	}
+	if err := writeFile(path.Join(p.options.Directory, proxy.CertChainFilename), chain, certFileMode); err != nil {
+		return time.Time{}, err
+	}
+
+	validity := cert.NotAfter.Sub(cert.NotBefore)
+	rotation := cert.NotBefore.Add(time.Duration(float64(validity) * p.options.RotationRatio))
+	glog.V(2).Infof("Provisioned certificate for %s valid until %v, rotating at %v",
+		p.options.Identity, cert.NotAfter, rotation)
+	return rotation, nil
+}
+
+// Run rotates the certificates starting at the rotation time until the
+// context is cancelled. Failed attempts are retried after the retry interval.
+func (p *Provisioner) Run(ctx context.Context, rotation time.Time) {
+	for {
+		timer := time.NewTimer(time.Until(rotation))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		next, err := p.Provision()
+		if err != nil {
+			glog.Warningf("Failed to rotate certificate for %s: %v", p.options.Identity, err)
+			next = time.Now().Add(p.options.RetryInterval)
+		}
+		rotation = next
+	}
+}
+
+// buildSubjectAltNameExtension encodes the URI as the subject alternate name
+func buildSubjectAltNameExtension(uri string) (pkix.Extension, error) {
+	// the URI is a general name with the tag 6
+	value, err := asn1.Marshal([]asn1.RawValue{{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(uri)}})
+	if err != nil {
+		return pkix.Extension{}, multierror.Prefix(err, "failed to encode subject alternate name:")
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: value}, nil
+}
+
+// writeFile replaces the file atomically so that the readers never observe
+// partial content
+func writeFile(name string, content []byte, mode os.FileMode) error {
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
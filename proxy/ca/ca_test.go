@@ -0,0 +1,275 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/pilot/proxy"
+)
+
+const identity = "spiffe://cluster.local/ns/default/sa/default"
+
+// makeLocalCA writes a self-signed root certificate and its key to the
+// directory and loads them as a local CA
+func makeLocalCA(t *testing.T, dir string) CA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"Istio"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(48 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := path.Join(dir, "ca-cert.pem")
+	keyFile := path.Join(dir, "ca-key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := NewLocalCA(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewLocalCA() => got error %v", err)
+	}
+	return ca
+}
+
+func makeTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %v", err)
+	}
+	return dir
+}
+
+func testOptions(dir string) Options {
+	options := DefaultOptions()
+	options.Identity = identity
+	options.Directory = dir
+	return options
+}
+
+func TestProvision(t *testing.T) {
+	dir := makeTempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ca := makeLocalCA(t, dir)
+	rotation, err := NewProvisioner(ca, testOptions(dir)).Provision()
+	if err != nil {
+		t.Fatalf("Provision() => got error %v", err)
+	}
+
+	chainFile := path.Join(dir, proxy.CertChainFilename)
+	keyFile := path.Join(dir, proxy.KeyFilename)
+	pair, err := tls.LoadX509KeyPair(chainFile, keyFile)
+	if err != nil {
+		t.Fatalf("certificate chain and key do not match: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := ioutil.ReadFile(path.Join(dir, proxy.RootCertFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := ca.RootCert()
+	if !bytes.Equal(root, expected) {
+		t.Errorf("root certificate does not match the CA certificate")
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(root)
+	if _, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("certificate does not verify against the root: %v", err)
+	}
+
+	found := false
+	for _, extension := range cert.Extensions {
+		if extension.Id.Equal(oidSubjectAltName) && bytes.Contains(extension.Value, []byte(identity)) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("certificate is missing the subject alternate name %s", identity)
+	}
+
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != keyFileMode {
+		t.Errorf("key file mode => got %v, want %v", info.Mode().Perm(), os.FileMode(keyFileMode))
+	}
+
+	want := cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore) / 2)
+	if !rotation.Equal(want) {
+		t.Errorf("rotation => got %v, want %v", rotation, want)
+	}
+}
+
+func TestSignCapsValidity(t *testing.T) {
+	dir := makeTempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	options := testOptions(dir)
+	options.TTL = 365 * 24 * time.Hour
+	if _, err := NewProvisioner(makeLocalCA(t, dir), options).Provision(); err != nil {
+		t.Fatalf("Provision() => got error %v", err)
+	}
+	pair, err := tls.LoadX509KeyPair(path.Join(dir, proxy.CertChainFilename), path.Join(dir, proxy.KeyFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.NotAfter.After(time.Now().Add(48 * time.Hour)) {
+		t.Errorf("certificate outlives the CA: %v", cert.NotAfter)
+	}
+}
+
+// countingCA counts the signing requests and fails the first ones
+type countingCA struct {
+	CA
+	mutex    sync.Mutex
+	signs    int
+	failures int
+}
+
+func (ca *countingCA) Sign(csr []byte, ttl time.Duration) ([]byte, error) {
+	ca.mutex.Lock()
+	ca.signs++
+	fail := ca.signs <= ca.failures
+	ca.mutex.Unlock()
+	if fail {
+		return nil, context.DeadlineExceeded
+	}
+	return ca.CA.Sign(csr, ttl)
+}
+
+func (ca *countingCA) count() int {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	return ca.signs
+}
+
+func TestRunRotates(t *testing.T) {
+	dir := makeTempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ca := &countingCA{CA: makeLocalCA(t, dir), failures: 1}
+	options := testOptions(dir)
+	options.RetryInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewProvisioner(ca, options).Run(ctx, time.Now())
+		close(done)
+	}()
+
+	// the first attempt fails and the retry succeeds
+	deadline := time.Now().Add(10 * time.Second)
+	for ca.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := ca.count(); got != 2 {
+		t.Errorf("signing requests => got %d, want 2", got)
+	}
+	if _, err := tls.LoadX509KeyPair(path.Join(dir, proxy.CertChainFilename),
+		path.Join(dir, proxy.KeyFilename)); err != nil {
+		t.Errorf("rotated certificate chain and key do not match: %v", err)
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	cases := []struct {
+		name    string
+		options func(*Options)
+		valid   bool
+	}{
+		{name: "valid", options: func(o *Options) {}, valid: true},
+		{name: "no identity", options: func(o *Options) { o.Identity = "" }},
+		{name: "no TTL", options: func(o *Options) { o.TTL = 0 }},
+		{name: "ratio too small", options: func(o *Options) { o.RotationRatio = 0 }},
+		{name: "ratio too large", options: func(o *Options) { o.RotationRatio = 1 }},
+		{name: "no retry", options: func(o *Options) { o.RetryInterval = 0 }},
+	}
+	for _, c := range cases {
+		options := testOptions("")
+		c.options(&options)
+		if got := ValidateOptions(options); (got == nil) != c.valid {
+			t.Errorf("%s: ValidateOptions() => got %v, want valid %t", c.name, got, c.valid)
+		}
+	}
+}
+
+func TestNewLocalCAErrors(t *testing.T) {
+	dir := makeTempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	garbage := path.Join(dir, "garbage.pem")
+	if err := ioutil.WriteFile(garbage, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLocalCA(path.Join(dir, "missing.pem"), garbage); err == nil {
+		t.Errorf("NewLocalCA() with a missing certificate => got no error")
+	}
+	if _, err := NewLocalCA(garbage, garbage); err == nil {
+		t.Errorf("NewLocalCA() with an invalid certificate => got no error")
+	}
+}
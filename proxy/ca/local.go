@@ -0,0 +1,150 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// clockSkew backdates the signed certificates to tolerate the clock skew
+// between the workloads
+const clockSkew = time.Minute
+
+// localCA signs the certificates with a root certificate and key read from
+// files. It needs no network access and suits the offline tests and the
+// single machine deployments.
+type localCA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// NewLocalCA creates a CA signing with the self-signed root certificate and
+// the key in the PEM encoded files
+func NewLocalCA(certFile, keyFile string) (CA, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("CA certificate %s is not PEM encoded", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, multierror.Prefix(err, "invalid CA certificate:")
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA certificate", certFile)
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, multierror.Prefix(err, "invalid CA key:")
+	}
+
+	return &localCA{cert: cert, certPEM: certPEM, key: key}, nil
+}
+
+func (ca *localCA) Sign(csrPEM []byte, ttl time.Duration) ([]byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("certificate signing request is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, multierror.Prefix(err, "invalid certificate signing request signature:")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	// the certificates do not outlive the CA
+	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+
+	// the subject alternate names are the only requested extensions honored
+	var extensions []pkix.Extension
+	for _, extension := range csr.Extensions {
+		if extension.Id.Equal(oidSubjectAltName) {
+			extensions = append(extensions, extension)
+		}
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         csr.Subject,
+		NotBefore:       now.Add(-clockSkew),
+		NotAfter:        notAfter,
+		KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: extensions,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+func (ca *localCA) RootCert() ([]byte, error) {
+	return ca.certPEM, nil
+}
+
+// parsePrivateKey decodes a PEM encoded PKCS #1, PKCS #8, or EC private key
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("unsupported key format")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+	return signer, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// CertBundle names the files of a certificate bundle in the certificate
+// directory
+type CertBundle struct {
+	// CertChain is the PEM encoded certificate chain, leaf first
+	CertChain string
+
+	// Key is the PEM encoded private key of the leaf certificate
+	Key string
+
+	// RootCert is the PEM encoded root certificate validating the chain,
+	// optional
+	RootCert string
+}
+
+const (
+	// certExpiryThreshold is the remaining validity of the certificates
+	// below which their expiry is imminent
+	certExpiryThreshold = time.Hour
+)
+
+// certExpiryCheckInterval is the period of the certificate expiry checks
+var certExpiryCheckInterval = time.Minute
+
+// validateCertBundle checks that the certificate chain matches the key and
+// validates against the root certificate. It returns the expiry of the
+// chain, or the zero time if none of the bundle files exist.
+func validateCertBundle(dir string, bundle CertBundle) (time.Time, error) {
+	files := []string{bundle.CertChain, bundle.Key}
+	if bundle.RootCert != "" {
+		files = append(files, bundle.RootCert)
+	}
+
+	contents := make([][]byte, len(files))
+	var missing []string
+	for i, file := range files {
+		content, err := ioutil.ReadFile(path.Join(dir, file))
+		if os.IsNotExist(err) {
+			missing = append(missing, file)
+			continue
+		} else if err != nil {
+			return time.Time{}, err
+		}
+		contents[i] = content
+	}
+
+	// the certificates are not provisioned yet
+	if len(missing) == len(files) {
+		return time.Time{}, nil
+	}
+	if len(missing) > 0 {
+		return time.Time{}, fmt.Errorf("incomplete certificate bundle, missing %v", missing)
+	}
+
+	pair, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return time.Time{}, multierror.Prefix(err, "certificate chain does not match the key:")
+	}
+
+	chain := make([]*x509.Certificate, 0, len(pair.Certificate))
+	for _, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return time.Time{}, multierror.Prefix(err, "invalid certificate chain:")
+		}
+		chain = append(chain, cert)
+	}
+
+	if bundle.RootCert != "" {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(contents[2]) {
+			return time.Time{}, errors.New("root certificate is not PEM encoded")
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := chain[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return time.Time{}, multierror.Prefix(err, "certificate chain does not validate against the root:")
+		}
+	}
+
+	expiry := chain[0].NotAfter
+	for _, cert := range chain[1:] {
+		if cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+	if time.Now().After(expiry) {
+		return time.Time{}, fmt.Errorf("certificate chain expired at %v", expiry)
+	}
+	return expiry, nil
+}
+
+// validateCerts validates the certificate bundles of the sources and
+// returns their earliest expiry, or the zero time if none is provisioned
+func validateCerts(certs []CertSource) (expiry time.Time, errs error) {
+	for _, cert := range certs {
+		if cert.Bundle == nil {
+			continue
+		}
+		bundleExpiry, err := validateCertBundle(cert.Directory, *cert.Bundle)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, cert.Directory+":"))
+			continue
+		}
+		if !bundleExpiry.IsZero() && (expiry.IsZero() || bundleExpiry.Before(expiry)) {
+			expiry = bundleExpiry
+		}
+	}
+	return
+}
+
+// checkCertExpiry logs and reports the imminent expiry of the certificates
+func checkCertExpiry(expiry, now time.Time) bool {
+	if expiry.IsZero() || expiry.Sub(now) >= certExpiryThreshold {
+		certExpiring.Set(0)
+		return false
+	}
+	glog.Warningf("Certificates expire at %v, in %v", expiry, expiry.Sub(now))
+	certExpiring.Set(1)
+	return true
+}
@@ -0,0 +1,308 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var authBundle = CertBundle{
+	CertChain: CertChainFilename,
+	Key:       KeyFilename,
+	RootCert:  RootCertFilename,
+}
+
+// testCert is a certificate and its PEM encoded key
+type testCert struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pem    []byte
+	keyPEM []byte
+}
+
+// makeTestCert issues a certificate valid until the expiry, self-signed if
+// the issuer is nil
+func makeTestCert(t *testing.T, issuer *testCert, expiry time.Time) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{Organization: []string{"Istio"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              expiry,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  issuer == nil,
+	}
+	parent, signer := template, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:   cert,
+		key:    key,
+		pem:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// writeBundle writes the certificate chain, the key, and the root
+// certificate, skipping the empty ones
+func writeBundle(t *testing.T, dir string, chain, key, root []byte) {
+	for file, content := range map[string][]byte{
+		CertChainFilename: chain,
+		KeyFilename:       key,
+		RootCertFilename:  root,
+	} {
+		if content == nil {
+			continue
+		}
+		if err := ioutil.WriteFile(path.Join(dir, file), content, 0644); err != nil {
+			t.Fatalf("failed to write file %s (error %v)", file, err)
+		}
+	}
+}
+
+func makeCertsDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %v", err)
+	}
+	return dir
+}
+
+func TestValidateCertBundle(t *testing.T) {
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	root := makeTestCert(t, nil, time.Now().Add(48*time.Hour))
+	leaf := makeTestCert(t, root, expiry)
+	other := makeTestCert(t, nil, time.Now().Add(48*time.Hour))
+	expired := makeTestCert(t, root, time.Now().Add(-time.Minute))
+
+	cases := []struct {
+		name   string
+		chain  []byte
+		key    []byte
+		root   []byte
+		expiry time.Time
+		valid  bool
+	}{
+		{name: "not provisioned", valid: true},
+		{name: "valid", chain: leaf.pem, key: leaf.keyPEM, root: root.pem, expiry: expiry, valid: true},
+		{name: "missing root", chain: leaf.pem, key: leaf.keyPEM},
+		{name: "mismatched key", chain: leaf.pem, key: other.keyPEM, root: root.pem},
+		{name: "untrusted root", chain: leaf.pem, key: leaf.keyPEM, root: other.pem},
+		{name: "invalid root", chain: leaf.pem, key: leaf.keyPEM, root: []byte("root")},
+		{name: "expired", chain: expired.pem, key: expired.keyPEM, root: root.pem},
+	}
+	for _, c := range cases {
+		dir := makeCertsDir(t)
+		writeBundle(t, dir, c.chain, c.key, c.root)
+		got, err := validateCertBundle(dir, authBundle)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: validateCertBundle() => got error %v, want valid %t", c.name, err, c.valid)
+		} else if !got.Equal(c.expiry) {
+			t.Errorf("%s: validateCertBundle() => got expiry %v, want %v", c.name, got, c.expiry)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}
+}
+
+func TestValidateCertsEarliestExpiry(t *testing.T) {
+	root := makeTestCert(t, nil, time.Now().Add(48*time.Hour))
+	early := makeTestCert(t, root, time.Now().Add(time.Hour).Truncate(time.Second))
+	late := makeTestCert(t, root, time.Now().Add(24*time.Hour).Truncate(time.Second))
+
+	var certs []CertSource
+	for _, cert := range []*testCert{late, early} {
+		dir := makeCertsDir(t)
+		defer func() { _ = os.RemoveAll(dir) }()
+		writeBundle(t, dir, cert.pem, cert.keyPEM, root.pem)
+		certs = append(certs, CertSource{Directory: dir, Bundle: &authBundle})
+	}
+
+	// sources without a bundle are not validated
+	certs = append(certs, CertSource{Directory: "missing"})
+
+	expiry, err := validateCerts(certs)
+	if err != nil {
+		t.Fatalf("validateCerts() => got error %v", err)
+	}
+	if !expiry.Equal(early.cert.NotAfter) {
+		t.Errorf("validateCerts() => got expiry %v, want %v", expiry, early.cert.NotAfter)
+	}
+}
+
+func TestReloadRefusesInvalidCerts(t *testing.T) {
+	dir := makeCertsDir(t)
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	rejections := func() float64 {
+		var metric dto.Metric
+		if err := certRejections.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		return metric.GetCounter().GetValue()
+	}
+
+	scheduled := 0
+	agent := TestAgent{schedule: func(_ interface{}) { scheduled++ }}
+	certs := []CertSource{{Directory: dir, Files: []string{CertChainFilename}, Bundle: &authBundle}}
+	watcher := NewWatcher(DefaultProxyConfig(), testBackend, agent, certs, nil)
+	initial := rejections()
+
+	root := makeTestCert(t, nil, time.Now().Add(48*time.Hour))
+	leaf := makeTestCert(t, root, time.Now().Add(24*time.Hour))
+	writeBundle(t, dir, leaf.pem, leaf.keyPEM, root.pem)
+	watcher.Reload()
+	if scheduled != 1 {
+		t.Errorf("Reload() with valid certificates => got %d updates, want 1", scheduled)
+	}
+
+	other := makeTestCert(t, nil, time.Now().Add(48*time.Hour))
+	writeBundle(t, dir, nil, other.keyPEM, nil)
+	watcher.Reload()
+	if scheduled != 1 {
+		t.Errorf("Reload() with a mismatched key => got %d updates, want 1", scheduled)
+	}
+	if got := rejections() - initial; got != 1 {
+		t.Errorf("Reload() => got %v rejections, want 1", got)
+	}
+}
+
+func TestReloadStartsWithInvalidCerts(t *testing.T) {
+	dir := makeCertsDir(t)
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	scheduled := 0
+	agent := TestAgent{schedule: func(_ interface{}) { scheduled++ }}
+	certs := []CertSource{{Directory: dir, Files: []string{CertChainFilename}, Bundle: &authBundle}}
+	watcher := NewWatcher(DefaultProxyConfig(), testBackend, agent, certs, nil)
+
+	// the proxy starts with an incomplete bundle until the certificates are valid
+	other := makeTestCert(t, nil, time.Now().Add(48*time.Hour))
+	writeBundle(t, dir, nil, other.keyPEM, nil)
+	watcher.Reload()
+	watcher.Reload()
+	if scheduled != 2 {
+		t.Errorf("Reload() with an incomplete bundle => got %d updates, want 2", scheduled)
+	}
+
+	root := makeTestCert(t, nil, time.Now().Add(48*time.Hour))
+	leaf := makeTestCert(t, root, time.Now().Add(24*time.Hour))
+	writeBundle(t, dir, leaf.pem, leaf.keyPEM, root.pem)
+	watcher.Reload()
+	if scheduled != 3 {
+		t.Errorf("Reload() with valid certificates => got %d updates, want 3", scheduled)
+	}
+}
+
+func TestReloadWithRunningExpiredCerts(t *testing.T) {
+	dir := makeCertsDir(t)
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	scheduled := 0
+	agent := TestAgent{schedule: func(_ interface{}) { scheduled++ }}
+	certs := []CertSource{{Directory: dir, Files: []string{CertChainFilename}, Bundle: &authBundle}}
+	watcher := NewWatcher(DefaultProxyConfig(), testBackend, agent, certs, nil)
+
+	root := makeTestCert(t, nil, time.Now().Add(48*time.Hour))
+	leaf := makeTestCert(t, root, time.Now().Add(time.Second))
+	writeBundle(t, dir, leaf.pem, leaf.keyPEM, root.pem)
+	watcher.Reload()
+	if scheduled != 1 {
+		t.Errorf("Reload() with valid certificates => got %d updates, want 1", scheduled)
+	}
+
+	// the configuration reloads proceed with the running certificates once
+	// they expire
+	time.Sleep(time.Until(leaf.cert.NotAfter) + time.Second)
+	watcher.Reload()
+	if scheduled != 2 {
+		t.Errorf("Reload() with the running expired certificates => got %d updates, want 2", scheduled)
+	}
+
+	// but not with changed invalid certificates
+	other := makeTestCert(t, nil, time.Now().Add(48*time.Hour))
+	writeBundle(t, dir, other.pem, nil, nil)
+	watcher.Reload()
+	if scheduled != 2 {
+		t.Errorf("Reload() with a changed mismatched chain => got %d updates, want 2", scheduled)
+	}
+}
+
+func TestCheckCertExpiry(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		expiry   time.Time
+		expiring bool
+	}{
+		{expiry: time.Time{}},
+		{expiry: now.Add(24 * time.Hour)},
+		{expiry: now.Add(certExpiryThreshold / 2), expiring: true},
+	}
+	for _, c := range cases {
+		if got := checkCertExpiry(c.expiry, now); got != c.expiring {
+			t.Errorf("checkCertExpiry(%v) => got %t, want %t", c.expiry, got, c.expiring)
+		}
+		var metric dto.Metric
+		if err := certExpiring.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if got := metric.GetGauge().GetValue() == 1; got != c.expiring {
+			t.Errorf("checkCertExpiry(%v) => got expiring gauge %v", c.expiry, metric.GetGauge().GetValue())
+		}
+	}
+}
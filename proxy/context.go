@@ -44,6 +44,9 @@ type Environment struct {
 
 	// Mixer subject alternate name for mutual TLS
 	MixerSAN []string
+
+	// Extensions are the mesh settings that the mesh config API lacks
+	Extensions MeshExtensions
 }
 
 // Node defines the proxy attributes used by xDS identification
@@ -167,9 +170,14 @@ func DefaultMeshConfig() proxyconfig.MeshConfig {
 
 // ApplyMeshConfigDefaults returns a new MeshConfig decoded from the
 // input YAML with defaults applied to omitted configuration values.
+// The MeshExtensions keys of the input YAML are ignored.
 func ApplyMeshConfigDefaults(yaml string) (*proxyconfig.MeshConfig, error) {
 	out := DefaultMeshConfig()
-	if err := model.ApplyYAML(yaml, &out); err != nil {
+	js, err := stripMeshExtensions(yaml)
+	if err != nil {
+		return nil, multierror.Prefix(err, "failed to convert to proto.")
+	}
+	if err := model.ApplyJSON(js, &out); err != nil {
 		return nil, multierror.Prefix(err, "failed to convert to proto.")
 	}
 
@@ -99,3 +99,75 @@ defaultConfig:
 		t.Fatalf("Wrong default values:\n got %#v \nwant %#v", got, &want)
 	}
 }
+
+func TestApplyMeshExtensions(t *testing.T) {
+	yaml := `
+enableTracing: true
+accessLogFormat: json
+tcpAccessLog: true
+tracing:
+  driver: jaeger
+  address: jaeger-agent:6831
+  sampling: 0
+mirrorPercentages: [10, 25]
+`
+	mesh, err := proxy.ApplyMeshConfigDefaults(yaml)
+	if err != nil {
+		t.Fatalf("ApplyMeshConfigDefaults() failed: %v", err)
+	}
+	if want := proxy.DefaultMeshConfig(); !reflect.DeepEqual(mesh, &want) {
+		t.Errorf("ApplyMeshConfigDefaults() => got %#v, want %#v", mesh, &want)
+	}
+
+	got, err := proxy.ApplyMeshExtensions(yaml)
+	if err != nil {
+		t.Fatalf("ApplyMeshExtensions() failed: %v", err)
+	}
+	if got.AccessLogFormat != "json" || !got.TCPAccessLog {
+		t.Errorf("ApplyMeshExtensions() => got access log format %q and TCP access log %t",
+			got.AccessLogFormat, got.TCPAccessLog)
+	}
+	tracing := got.Tracing
+	if tracing.Driver != proxy.JaegerTracer || tracing.Address != "jaeger-agent:6831" ||
+		tracing.Sampling == nil || *tracing.Sampling != 0 {
+		t.Errorf("ApplyMeshExtensions() => got tracing %#v", tracing)
+	}
+	if want := []int32{10, 25}; !reflect.DeepEqual(got.MirrorPercentages, want) {
+		t.Errorf("ApplyMeshExtensions() => got mirror percentages %v, want %v", got.MirrorPercentages, want)
+	}
+
+	if got, err = proxy.ApplyMeshExtensions("enableTracing: true"); err != nil ||
+		!reflect.DeepEqual(*got, proxy.DefaultMeshExtensions()) {
+		t.Errorf("ApplyMeshExtensions() => got %#v, %v, want the default extensions", got, err)
+	}
+	if _, err = proxy.ApplyMeshExtensions("tracing:\n  driver: jaeger\n"); err == nil {
+		t.Error("ApplyMeshExtensions() => expected error on a Jaeger driver without an address")
+	}
+	if _, err = proxy.ApplyMeshExtensions("mirrorPercentages: [100]"); err == nil {
+		t.Error("ApplyMeshExtensions() => expected error on a mirror percentage without runtime")
+	}
+}
+
+func TestValidateTracingConfig(t *testing.T) {
+	half, above := 0.5, 150.0
+	cases := []struct {
+		tracing proxy.TracingConfig
+		valid   bool
+	}{
+		{tracing: proxy.DefaultTracingConfig(), valid: true},
+		{tracing: proxy.TracingConfig{Driver: proxy.ZipkinTracer, Sampling: &half}, valid: true},
+		{tracing: proxy.TracingConfig{Driver: proxy.ZipkinTracer, Sampling: &above}, valid: false},
+		{tracing: proxy.TracingConfig{Driver: proxy.ZipkinTracer, Address: "zipkin:9411"}, valid: false},
+		{tracing: proxy.TracingConfig{Driver: proxy.JaegerTracer, Address: "jaeger-agent:6831"}, valid: true},
+		{tracing: proxy.TracingConfig{Driver: proxy.JaegerTracer}, valid: false},
+		{tracing: proxy.TracingConfig{Driver: proxy.LightStepTracer, Address: "lightstep:8080",
+			AccessTokenFile: "/etc/lightstep/token"}, valid: true},
+		{tracing: proxy.TracingConfig{Driver: proxy.LightStepTracer, Address: "lightstep:8080"}, valid: false},
+		{tracing: proxy.TracingConfig{Driver: "datadog"}, valid: false},
+	}
+	for _, c := range cases {
+		if got := proxy.ValidateTracingConfig(c.tracing); (got == nil) != c.valid {
+			t.Errorf("ValidateTracingConfig(%#v) => got %v, want valid %t", c.tracing, got, c.valid)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"strings"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+const (
+	// AccessLogJSON selects the JSON access log formats
+	AccessLogJSON = "json"
+
+	// httpJSONAccessLogFormat logs the HTTP requests as JSON objects. The
+	// route rule is not logged since the v1 access logs have no command
+	// operator for the matched route.
+	// See: https://lyft.github.io/envoy/docs/configuration/http_conn_man/access_log.html#format-rules
+	httpJSONAccessLogFormat = `{"start_time":"%START_TIME%","method":"%REQ(:METHOD)%",` +
+		`"path":"%REQ(X-ENVOY-ORIGINAL-PATH?:PATH)%","protocol":"%PROTOCOL%",` +
+		`"response_code":"%RESPONSE_CODE%","response_flags":"%RESPONSE_FLAGS%",` +
+		`"bytes_received":"%BYTES_RECEIVED%","bytes_sent":"%BYTES_SENT%","duration":"%DURATION%",` +
+		`"upstream_service_time":"%RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)%",` +
+		`"x_forwarded_for":"%REQ(X-FORWARDED-FOR)%","user_agent":"%REQ(USER-AGENT)%",` +
+		`"request_id":"%REQ(X-REQUEST-ID)%","authority":"%REQ(:AUTHORITY)%",` +
+		`"upstream_host":"%UPSTREAM_HOST%","upstream_cluster":"%UPSTREAM_CLUSTER%"}` + "\n"
+
+	// tcpJSONAccessLogFormat logs the TCP connections as JSON objects
+	tcpJSONAccessLogFormat = `{"start_time":"%START_TIME%","response_flags":"%RESPONSE_FLAGS%",` +
+		`"bytes_received":"%BYTES_RECEIVED%","bytes_sent":"%BYTES_SENT%","duration":"%DURATION%",` +
+		`"upstream_host":"%UPSTREAM_HOST%","upstream_cluster":"%UPSTREAM_CLUSTER%"}` + "\n"
+)
+
+// accessLogFormats returns the HTTP and the TCP access log formats. A
+// template is used for both and terminated by a new line.
+func accessLogFormats(format string) (string, string) {
+	switch format {
+	case "":
+		return "", ""
+	case AccessLogJSON:
+		return httpJSONAccessLogFormat, tcpJSONAccessLogFormat
+	}
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	return format, format
+}
+
+// applyAccessLogs sets the format of the access logs on the HTTP connection
+// managers, adds the access logs to the TCP proxies if enabled, and removes
+// the access logs from the listeners of the services without access logs.
+func applyAccessLogs(listeners Listeners, mesh *proxyconfig.MeshConfig, format string, tcp bool) {
+	httpFormat, tcpFormat := accessLogFormats(format)
+	for _, listener := range listeners {
+		for _, filter := range listener.Filters {
+			switch config := filter.Config.(type) {
+			case *HTTPFilterConfig:
+				if listener.accessLogDisabled {
+					config.AccessLog = nil
+				}
+				for i := range config.AccessLog {
+					config.AccessLog[i].Format = httpFormat
+				}
+			case *TCPProxyFilterConfig:
+				if tcp && mesh.AccessLogFile != "" && !listener.accessLogDisabled {
+					config.AccessLog = []AccessLog{{
+						Path:   mesh.AccessLogFile,
+						Format: tcpFormat,
+					}}
+				}
+			}
+		}
+	}
+}
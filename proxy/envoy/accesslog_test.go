@@ -0,0 +1,150 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	cases := []struct {
+		format, http, tcp string
+	}{
+		{format: "", http: "", tcp: ""},
+		{format: AccessLogJSON, http: httpJSONAccessLogFormat, tcp: tcpJSONAccessLogFormat},
+		{format: "%START_TIME% %UPSTREAM_HOST%", http: "%START_TIME% %UPSTREAM_HOST%\n",
+			tcp: "%START_TIME% %UPSTREAM_HOST%\n"},
+	}
+	for _, c := range cases {
+		if http, tcp := accessLogFormats(c.format); http != c.http || tcp != c.tcp {
+			t.Errorf("accessLogFormats(%q) => got %q and %q, want %q and %q", c.format, http, tcp, c.http, c.tcp)
+		}
+	}
+}
+
+func TestApplyAccessLogs(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	tcpRoutes := &TCPRouteConfig{Routes: []*TCPRoute{{Cluster: "tcp"}}}
+	build := func(disabled bool) Listeners {
+		httpListener := buildHTTPListener(&mesh, proxy.Node{Type: proxy.Sidecar}, nil, &HTTPRouteConfig{},
+			WildcardAddress, 80, "80", false, EgressTraceOperation)
+		tcpListener := buildTCPListener(tcpRoutes, WildcardAddress, 9000, model.ProtocolTCP)
+		httpListener.accessLogDisabled = disabled
+		tcpListener.accessLogDisabled = disabled
+		return Listeners{httpListener, tcpListener}
+	}
+
+	listeners := build(false)
+	applyAccessLogs(listeners, &mesh, AccessLogJSON, true)
+	httpLogs := listeners[0].Filters[0].Config.(*HTTPFilterConfig).AccessLog
+	want := []AccessLog{{Path: mesh.AccessLogFile, Format: httpJSONAccessLogFormat}}
+	if !reflect.DeepEqual(httpLogs, want) {
+		t.Errorf("applyAccessLogs() => got HTTP access logs %#v, want %#v", httpLogs, want)
+	}
+	tcpLogs := listeners[1].Filters[0].Config.(*TCPProxyFilterConfig).AccessLog
+	want = []AccessLog{{Path: mesh.AccessLogFile, Format: tcpJSONAccessLogFormat}}
+	if !reflect.DeepEqual(tcpLogs, want) {
+		t.Errorf("applyAccessLogs() => got TCP access logs %#v, want %#v", tcpLogs, want)
+	}
+
+	listeners = build(true)
+	applyAccessLogs(listeners, &mesh, "", true)
+	if logs := listeners[0].Filters[0].Config.(*HTTPFilterConfig).AccessLog; logs != nil {
+		t.Errorf("applyAccessLogs() => got HTTP access logs %#v for a disabled service", logs)
+	}
+	if logs := listeners[1].Filters[0].Config.(*TCPProxyFilterConfig).AccessLog; logs != nil {
+		t.Errorf("applyAccessLogs() => got TCP access logs %#v for a disabled service", logs)
+	}
+}
+
+func TestBuildInboundListenersAccessLogDisabled(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+
+	service := *mock.HelloService
+	service.AccessLogDisabled = true
+	instances := []*model.ServiceInstance{
+		mock.MakeInstance(&service, mock.PortHTTP, 0),
+		mock.MakeInstance(mock.WorldService, mock.PortHTTP, 0),
+	}
+
+	listeners, _ := buildInboundListeners(&mesh, proxy.Node{Type: proxy.Sidecar}, instances, store)
+	if len(listeners) != 2 || !listeners[0].accessLogDisabled || listeners[1].accessLogDisabled {
+		t.Errorf("buildInboundListeners() => got listeners %v, want the access log disabled for hello only", listeners)
+	}
+}
+
+func TestBuildOutboundTCPListenersAccessLogDisabled(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+
+	// the services without an address are served by the wildcard listeners
+	service := *mock.HelloService
+	service.Address = ""
+	service.AccessLogDisabled = true
+	for _, headless := range []bool{false, true} {
+		service.LoadBalancingDisabled = headless
+		listeners, _ := buildOutboundTCPListeners(&mesh, proxy.Node{Type: proxy.Sidecar}, nil,
+			[]*model.Service{&service}, store)
+		if len(listeners) == 0 {
+			t.Fatalf("buildOutboundTCPListeners() => got no listeners")
+		}
+		for _, listener := range listeners {
+			if !listener.accessLogDisabled {
+				t.Errorf("buildOutboundTCPListeners() => got the access log enabled on %s, headless %t",
+					listener.Name, headless)
+			}
+		}
+	}
+}
+
+func TestBuildOutboundTCPListenersSharedAccessLog(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+
+	// the services without an address share the wildcard listeners
+	hello, world := *mock.HelloService, *mock.WorldService
+	hello.Address, world.Address = "", ""
+	hello.AccessLogDisabled = true
+	quiet := world
+	quiet.AccessLogDisabled = true
+	cases := []struct {
+		services []*model.Service
+		disabled bool
+	}{
+		{services: []*model.Service{&hello, &world}, disabled: false},
+		{services: []*model.Service{&world, &hello}, disabled: false},
+		{services: []*model.Service{&hello, &quiet}, disabled: true},
+	}
+	for _, c := range cases {
+		listeners, _ := buildOutboundTCPListeners(&mesh, proxy.Node{Type: proxy.Sidecar}, nil, c.services, store)
+		if len(listeners) == 0 {
+			t.Fatalf("buildOutboundTCPListeners() => got no listeners")
+		}
+		for _, listener := range listeners {
+			if listener.accessLogDisabled != c.disabled {
+				t.Errorf("buildOutboundTCPListeners(%s, %s) => got access log disabled %t on %s, want %t",
+					c.services[0].Hostname, c.services[1].Hostname, listener.accessLogDisabled, listener.Name,
+					c.disabled)
+			}
+		}
+	}
+}
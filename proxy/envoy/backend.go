@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"path"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+// BackendName is the name of the Envoy proxy backend
+const BackendName = "envoy"
+
+// NewBackend creates the Envoy proxy backend hot restarting Envoy with the
+// generated bootstrap, or the bootstrap rendered from the template
+func NewBackend(options proxy.BackendOptions, bootstrap BootstrapConfig) proxy.Backend {
+	r := renderer{
+		node:      options.Node,
+		bootstrap: bootstrap,
+		pilotSAN:  options.PilotSAN,
+	}
+	backend := proxy.Backend{
+		Proxy: NewProxy(options.Config, options.Node.ServiceNode()),
+	}
+
+	// the template and the mesh config changes trigger reloads
+	if bootstrap.TemplateFile != "" {
+		backend.Directories = append(backend.Directories, path.Dir(bootstrap.TemplateFile))
+	}
+	if bootstrap.MeshConfigFile != "" {
+		backend.Directories = append(backend.Directories, path.Dir(bootstrap.MeshConfigFile))
+	}
+
+	// the changes of the reachable discovery addresses trigger reloads, and
+	// the proxy starts with the addresses reachable within the connect timeout
+	if len(bootstrap.DiscoveryAddresses) > 0 {
+		r.failover = newDiscoveryFailover(bootstrap.DiscoveryAddresses, convertDuration(options.Config.ConnectTimeout))
+		r.failover.initialize()
+		backend.Monitor = r.failover.run
+	}
+
+	backend.Renderer = r
+	return backend
+}
+
+// renderer builds the Envoy bootstrap
+type renderer struct {
+	node      proxy.Node
+	bootstrap BootstrapConfig
+	pilotSAN  []string
+
+	// failover selects the discovery addresses if set
+	failover *discoveryFailover
+}
+
+func (r renderer) Render(config proxyconfig.ProxyConfig, certHash []byte) (interface{}, error) {
+	extensions, err := proxy.ReadMeshExtensions(r.bootstrap.MeshConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	out := buildConfig(config, r.bootstrap, *extensions, r.pilotSAN)
+	out.Hash = certHash
+	if r.failover != nil {
+		r.failover.setTimeout(convertDuration(config.ConnectTimeout))
+		applyDiscoveryAddresses(out, r.failover.current())
+	}
+
+	if r.bootstrap.TemplateFile != "" {
+		data := buildBootstrapTemplateData(config, r.node, r.pilotSAN, out)
+		rendered, err := renderBootstrap(r.bootstrap.TemplateFile, data)
+		if err != nil {
+			return nil, multierror.Prefix(err, fmt.Sprintf("failed to render bootstrap template %s:",
+				r.bootstrap.TemplateFile))
+		}
+		out.rendered = rendered
+	}
+	return out, nil
+}
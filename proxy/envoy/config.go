@@ -20,7 +20,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -64,7 +67,61 @@ func (conf *Config) WriteFile(fname string) error {
 	return file.Close()
 }
 
+// writeRuntime saves the runtime values of the proxy under the config path,
+// one file per key with the dots of the key separating the directories. The
+// values the agent saved for the keys missing from the runtime are removed,
+// while the other files of the runtime directory are kept.
+func (conf *Config) writeRuntime(configPath string) error {
+	root := path.Join(configPath, RuntimeDirectory, RuntimeSubdirectory)
+	if err := removeRuntime(root, conf.runtime); err != nil {
+		return multierror.Prefix(err, "failed to remove proxy runtime")
+	}
+	for key, value := range conf.runtime {
+		fname := path.Join(root, strings.Replace(key, ".", "/", -1))
+		if err := os.MkdirAll(path.Dir(fname), 0700); err != nil {
+			return multierror.Prefix(err, "failed to create directory for proxy runtime")
+		}
+		if err := ioutil.WriteFile(fname, []byte(value), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// agentRuntimeKey returns true for the runtime keys saved by the agent
+func agentRuntimeKey(key string) bool {
+	return strings.HasPrefix(key, MirrorRuntimeKeyPrefix) || key == TraceSamplingRuntimeKey
+}
+
+// removeRuntime removes the files of the runtime keys saved by the agent that
+// are missing from the runtime
+func removeRuntime(root string, runtime map[string]string) error {
+	err := filepath.Walk(root, func(fname string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, fname)
+		if err != nil {
+			return err
+		}
+		key := strings.Replace(filepath.ToSlash(rel), "/", ".", -1)
+		if _, exists := runtime[key]; exists || !agentRuntimeKey(key) {
+			return nil
+		}
+		return os.Remove(fname)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
 func (conf *Config) Write(w io.Writer) error {
+	if conf.rendered != nil {
+		_, err := w.Write(conf.rendered)
+		return err
+	}
+
 	out, err := json.MarshalIndent(&conf, "", "  ")
 	if err != nil {
 		return err
@@ -74,9 +131,59 @@ func (conf *Config) Write(w io.Writer) error {
 	return err
 }
 
+// BootstrapConfig extends the proxy config with the supporting services of
+// the proxy that are not part of the proxy config API yet
+type BootstrapConfig struct {
+	// MeshConfigFile is the path of the mounted mesh config file holding the
+	// tracing backend and the mirror percentages of the proxy, reloaded on
+	// changes; all the spans are reported to the Zipkin address and no
+	// requests are mirrored by percentage if empty
+	MeshConfigFile string
+
+	// RateLimitAddress is the address of the rate limit service (e.g.
+	// ratelimit:8081); the requests are not rate limited if empty
+	RateLimitAddress string
+
+	// TemplateFile is the path of the Go template rendering the bootstrap
+	// from BootstrapTemplateData; the generated bootstrap is used if empty
+	TemplateFile string
+
+	// DiscoveryAddresses replace the discovery address of the proxy config
+	// with prioritized addresses that the proxy fails over across
+	DiscoveryAddresses []DiscoveryAddress
+}
+
+// DefaultBootstrapConfig reports the spans to Zipkin and disables rate limiting
+func DefaultBootstrapConfig() BootstrapConfig {
+	return BootstrapConfig{}
+}
+
+// ValidateBootstrapConfig checks the mesh extensions, the address of the rate
+// limit service, the bootstrap template, and the discovery addresses
+func ValidateBootstrapConfig(bootstrap BootstrapConfig) (errs error) {
+	if _, err := proxy.ReadMeshExtensions(bootstrap.MeshConfigFile); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if bootstrap.RateLimitAddress != "" {
+		if err := model.ValidateProxyAddress(bootstrap.RateLimitAddress); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "invalid rate limit address:"))
+		}
+	}
+	if bootstrap.TemplateFile != "" {
+		if _, err := parseBootstrapTemplate(bootstrap.TemplateFile); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "invalid bootstrap template:"))
+		}
+	}
+	if err := ValidateDiscoveryAddresses(bootstrap.DiscoveryAddresses); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return
+}
+
 // buildConfig creates a proxy config with discovery services and admin port
 // it creates config for Ingress, Egress and Sidecar proxies
-func buildConfig(config proxyconfig.ProxyConfig, pilotSAN []string) *Config {
+func buildConfig(config proxyconfig.ProxyConfig, bootstrap BootstrapConfig, extensions proxy.MeshExtensions,
+	pilotSAN []string) *Config {
 	listeners := Listeners{}
 
 	clusterRDS := buildCluster(config.DiscoveryAddress, RDSName, config.ConnectTimeout)
@@ -119,10 +226,29 @@ func buildConfig(config proxyconfig.ProxyConfig, pilotSAN []string) *Config {
 		out.ClusterManager.CDS.Cluster.SSLContext = sslContext
 	}
 
-	if config.ZipkinAddress != "" {
+	var collector *Cluster
+	if out.Tracing, collector = buildTracing(config, extensions.Tracing); collector != nil {
+		out.ClusterManager.Clusters = append(out.ClusterManager.Clusters, collector)
+	}
+
+	// the runtime holds the percentages of the mirrored requests listed in the
+	// mesh config, and the trace sampling
+	runtime := buildMirrorRuntime(extensions.MirrorPercentages)
+	if out.Tracing != nil && extensions.Tracing.Sampling != nil {
+		runtime[TraceSamplingRuntimeKey] = buildTraceSamplingRuntime(*extensions.Tracing.Sampling)
+	}
+	if len(runtime) > 0 {
+		out.RootRuntime = &RootRuntime{
+			SymlinkRoot:  path.Join(config.ConfigPath, RuntimeDirectory),
+			Subdirectory: RuntimeSubdirectory,
+		}
+		out.runtime = runtime
+	}
+
+	if bootstrap.RateLimitAddress != "" {
 		out.ClusterManager.Clusters = append(out.ClusterManager.Clusters,
-			buildCluster(config.ZipkinAddress, ZipkinCollectorCluster, config.ConnectTimeout))
-		out.Tracing = buildZipkinTracing()
+			buildRateLimitCluster(bootstrap.RateLimitAddress, config.ConnectTimeout))
+		out.RateLimitService = buildRateLimitService()
 	}
 
 	return out
@@ -142,13 +268,16 @@ func buildListeners(env proxy.Environment, node proxy.Node) (Listeners, error) {
 		}
 		listeners, _ := buildSidecarListenersClusters(env.Mesh, instances,
 			services, env.ManagementPorts(node.IPAddress), node, env.IstioConfigStore)
+		applyAccessLogs(listeners, env.Mesh, env.Extensions.AccessLogFormat, env.Extensions.TCPAccessLog)
 		return listeners, nil
 	case proxy.Ingress:
 		instances, err := env.HostInstances(map[string]bool{node.IPAddress: true})
 		if err != nil {
 			return Listeners{}, err
 		}
-		return buildIngressListeners(env.Mesh, instances, env.ServiceDiscovery, env.IstioConfigStore, node), nil
+		listeners := buildIngressListeners(env.Mesh, instances, env.ServiceDiscovery, env.IstioConfigStore, node)
+		applyAccessLogs(listeners, env.Mesh, env.Extensions.AccessLogFormat, env.Extensions.TCPAccessLog)
+		return listeners, nil
 	}
 	return nil, nil
 }
@@ -270,11 +399,15 @@ func buildSidecarListenersClusters(
 		// only HTTP outbound clusters are needed
 		httpOutbound := buildOutboundHTTPRoutes(mesh, node, instances, services, config)
 		httpOutbound = buildEgressHTTPRoutes(mesh, node, instances, config, httpOutbound)
+		applyMirrorPorts(httpOutbound, services)
 		clusters = append(clusters,
 			httpOutbound.clusters()...)
-		listeners = append(listeners,
-			buildHTTPListener(mesh, node, instances, nil, listenAddress, int(mesh.ProxyHttpPort),
-				RDSAll, useRemoteAddress, traceOperation))
+		listener := buildHTTPListener(mesh, node, instances, nil, listenAddress, int(mesh.ProxyHttpPort),
+			RDSAll, useRemoteAddress, traceOperation)
+		outboundRoutes := httpOutbound.combine()
+		insertCorsFilter(listener, outboundRoutes)
+		insertRateLimitFilter(listener, outboundRoutes)
+		listeners = append(listeners, listener)
 		// TODO: need inbound listeners in HTTP_PROXY case, with dedicated ingress listener.
 	}
 
@@ -306,20 +439,27 @@ func buildRDSRoute(mesh *proxyconfig.MeshConfig, node proxy.Node, routeName stri
 		}
 		httpConfigs = buildOutboundHTTPRoutes(mesh, node, instances, services, config)
 		httpConfigs = buildEgressHTTPRoutes(mesh, node, instances, config, httpConfigs)
+		applyMirrorPorts(httpConfigs, services)
 	default:
 		return nil, errors.New("Unrecognized node type")
 	}
 
+	var routeConfig *HTTPRouteConfig
 	if routeName == RDSAll {
-		return httpConfigs.combine(), nil
+		routeConfig = httpConfigs.combine()
+	} else {
+		port, err := strconv.Atoi(routeName)
+		if err != nil {
+			return nil, err
+		}
+		routeConfig = httpConfigs[port]
 	}
 
-	port, err := strconv.Atoi(routeName)
-	if err != nil {
-		return nil, err
+	// the response header operations of the routes apply to the route config
+	if routeConfig != nil {
+		routeConfig.applyResponseHeaders()
 	}
-
-	return httpConfigs[port], nil
+	return routeConfig, nil
 }
 
 // buildHTTPListener constructs a listener for the network interface address and port.
@@ -389,7 +529,7 @@ func buildHTTPListener(mesh *proxyconfig.MeshConfig, node proxy.Node, instances
 		config.RouteConfig = routeConfig
 	}
 
-	return &Listener{
+	listener := &Listener{
 		BindToPort: true,
 		Name:       fmt.Sprintf("http_%s_%d", ip, port),
 		Address:    fmt.Sprintf("tcp://%s:%d", ip, port),
@@ -399,6 +539,10 @@ func buildHTTPListener(mesh *proxyconfig.MeshConfig, node proxy.Node, instances
 			Config: config,
 		}},
 	}
+	insertCorsFilter(listener, routeConfig)
+	insertRateLimitFilter(listener, routeConfig)
+
+	return listener
 }
 
 // shouldApplyAuth returns true if service's authentication policy is enable, or the mesh's auth
@@ -486,11 +630,12 @@ func buildTCPListener(tcpConfig *TCPRouteConfig, ip string, port int, protocol m
 // buildOutboundListeners combines HTTP routes and TCP listeners
 func buildOutboundListeners(mesh *proxyconfig.MeshConfig, sidecar proxy.Node, instances []*model.ServiceInstance,
 	services []*model.Service, config model.IstioConfigStore) (Listeners, Clusters) {
-	listeners, clusters := buildOutboundTCPListeners(mesh, sidecar, services)
+	listeners, clusters := buildOutboundTCPListeners(mesh, sidecar, instances, services, config)
 
 	// note that outbound HTTP routes are supplied through RDS
 	httpOutbound := buildOutboundHTTPRoutes(mesh, sidecar, instances, services, config)
 	httpOutbound = buildEgressHTTPRoutes(mesh, sidecar, instances, config, httpOutbound)
+	applyMirrorPorts(httpOutbound, services)
 
 	for port, routeConfig := range httpOutbound {
 		operation := EgressTraceOperation
@@ -549,6 +694,11 @@ func buildDestinationHTTPRoutes(service *model.Service,
 			routes = append(routes, buildDefaultRoute(cluster))
 		}
 
+		// sticky sessions are configured on the routes to the ring hash clusters
+		for _, route := range routes {
+			applyRouteHashPolicy(route, instances, config)
+		}
+
 		return routes
 
 	case model.ProtocolHTTPS:
@@ -615,13 +765,15 @@ func buildOutboundHTTPRoutes(mesh *proxyconfig.MeshConfig, sidecar proxy.Node,
 // Connections to the ports of non-load balanced services are directed to
 // the connection's original destination. This avoids costly queries of instance
 // IPs and ports, but requires that ports of non-load balanced service be unique.
+// Route rules apply to the connections to load balanced services only.
 func buildOutboundTCPListeners(mesh *proxyconfig.MeshConfig, sidecar proxy.Node,
-	services []*model.Service) (Listeners, Clusters) {
+	instances []*model.ServiceInstance, services []*model.Service,
+	config model.IstioConfigStore) (Listeners, Clusters) {
 	tcpListeners := make(Listeners, 0)
 	tcpClusters := make(Clusters, 0)
 
 	var originalDstCluster *Cluster
-	wildcardListenerPorts := make(map[int]bool)
+	wildcardListeners := make(map[int]*Listener)
 	for _, service := range services {
 		if service.External() {
 			continue // TODO TCP external services not currently supported
@@ -634,13 +786,14 @@ func buildOutboundTCPListeners(mesh *proxyconfig.MeshConfig, sidecar proxy.Node,
 					// ensure only one wildcard listener is created per port if its headless service
 					// or if its for a Router (where there is one wildcard TCP listener per port)
 					// or if this is in environment where services don't get a dummy load balancer IP.
-					if wildcardListenerPorts[servicePort.Port] {
+					// the shared listener logs unless all of its services opt out
+					if listener := wildcardListeners[servicePort.Port]; listener != nil {
 						glog.V(4).Infof("Multiple definitions for port %d", servicePort.Port)
+						listener.accessLogDisabled = listener.accessLogDisabled && service.AccessLogDisabled
 						continue
 					}
-					wildcardListenerPorts[servicePort.Port] = true
 
-					var cluster *Cluster
+					var routes []*TCPRoute
 					// Router mode cannot handle headless services
 					if service.LoadBalancingDisabled && sidecar.Type != proxy.Router {
 						if originalDstCluster == nil {
@@ -648,26 +801,29 @@ func buildOutboundTCPListeners(mesh *proxyconfig.MeshConfig, sidecar proxy.Node,
 								"orig-dst-cluster-tcp", mesh.ConnectTimeout)
 							tcpClusters = append(tcpClusters, originalDstCluster)
 						}
-						cluster = originalDstCluster
+						routes = []*TCPRoute{buildTCPRoute(originalDstCluster, nil)}
 					} else {
-						cluster = buildOutboundCluster(service.Hostname, servicePort, nil)
-						tcpClusters = append(tcpClusters, cluster)
+						routes = buildOutboundTCPRoutes(service, servicePort, nil, instances, config)
+						for _, route := range routes {
+							tcpClusters = append(tcpClusters, route.clusterRef)
+						}
 					}
-					route := buildTCPRoute(cluster, nil)
-					config := &TCPRouteConfig{Routes: []*TCPRoute{route}}
-					listener := buildTCPListener(
-						config, WildcardAddress, servicePort.Port, servicePort.Protocol)
+					listener := buildTCPListener(&TCPRouteConfig{Routes: routes},
+						WildcardAddress, servicePort.Port, servicePort.Protocol)
+					listener.accessLogDisabled = service.AccessLogDisabled
 					if sidecar.Type == proxy.Router {
 						listener.BindToPort = true
 					}
+					wildcardListeners[servicePort.Port] = listener
 					tcpListeners = append(tcpListeners, listener)
 				} else {
-					cluster := buildOutboundCluster(service.Hostname, servicePort, nil)
-					route := buildTCPRoute(cluster, []string{service.Address})
-					config := &TCPRouteConfig{Routes: []*TCPRoute{route}}
-					listener := buildTCPListener(
-						config, service.Address, servicePort.Port, servicePort.Protocol)
-					tcpClusters = append(tcpClusters, cluster)
+					routes := buildOutboundTCPRoutes(service, servicePort, []string{service.Address}, instances, config)
+					listener := buildTCPListener(&TCPRouteConfig{Routes: routes},
+						service.Address, servicePort.Port, servicePort.Protocol)
+					listener.accessLogDisabled = service.AccessLogDisabled
+					for _, route := range routes {
+						tcpClusters = append(tcpClusters, route.clusterRef)
+					}
 					tcpListeners = append(tcpListeners, listener)
 				}
 			}
@@ -677,6 +833,30 @@ func buildOutboundTCPListeners(mesh *proxyconfig.MeshConfig, sidecar proxy.Node,
 	return tcpListeners, tcpClusters
 }
 
+// buildOutboundTCPRoutes lists the TCP routes for the route rules that apply
+// to the source instances and the destination service. If the service also
+// has HTTP ports, only the rules with an L4 match condition apply to its TCP
+// ports, since the other rules are meant for the HTTP ports.
+func buildOutboundTCPRoutes(service *model.Service, servicePort *model.Port, addresses []string,
+	instances []*model.ServiceInstance, config model.IstioConfigStore) []*TCPRoute {
+	httpService := false
+	for _, port := range service.Ports {
+		if port.Protocol.IsHTTP() {
+			httpService = true
+		}
+	}
+
+	rules := make([]model.Config, 0)
+	for _, rule := range config.RouteRules(instances, service.Hostname) {
+		match := rule.Spec.(*proxyconfig.RouteRule).Match
+		if !httpService || (match != nil && match.Tcp != nil) {
+			rules = append(rules, rule)
+		}
+	}
+	model.SortRouteRules(rules)
+	return buildTCPRoutes(rules, service, servicePort, addresses)
+}
+
 // buildInboundListeners creates listeners for the server-side (inbound)
 // configuration for co-located service instances. The function also returns
 // all inbound clusters since they are statically declared in the proxy
@@ -771,6 +951,7 @@ func buildInboundListeners(mesh *proxyconfig.MeshConfig, sidecar proxy.Node,
 		}
 
 		if listener != nil {
+			listener.accessLogDisabled = instance.Service.AccessLogDisabled
 			mayApplyInboundAuth(listener, mesh, endpoint.ServicePort.AuthenticationPolicy)
 			listeners = append(listeners, listener)
 		}
@@ -831,10 +1012,15 @@ func buildEgressVirtualHost(rule *proxyconfig.EgressRule,
 
 	if len(routes) > 0 {
 		// Set the destination clusters to the cluster we computed above.
-		// Services defined via egress rules do not have labels and hence no weighted clusters
+		// Services defined via egress rules do not have labels and hence no weighted clusters,
+		// and the original destination cluster is not load balanced by hash
 		for _, route := range routes {
 			route.Cluster = externalTrafficCluster.Name
 			route.clusters = []*Cluster{externalTrafficCluster}
+			route.HashPolicy = nil
+			if route.mirror != nil {
+				route.clusters = append(route.clusters, route.mirror)
+			}
 		}
 	}
 
@@ -16,6 +16,8 @@ package envoy
 
 import (
 	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
 	"sort"
 	"testing"
@@ -353,7 +355,7 @@ func TestProxyConfig(t *testing.T) {
 
 	proxyConfig := makeProxyConfig()
 	for _, c := range cases {
-		config := buildConfig(proxyConfig, nil)
+		config := buildConfig(proxyConfig, DefaultBootstrapConfig(), proxy.DefaultMeshExtensions(), nil)
 		if config == nil {
 			t.Fatal("Failed to generate config")
 		}
@@ -378,7 +380,7 @@ func TestProxyConfigControlPlaneAuth(t *testing.T) {
 
 	proxyConfig := makeProxyConfigControlPlaneAuth()
 	for _, c := range cases {
-		config := buildConfig(proxyConfig, pilotSAN)
+		config := buildConfig(proxyConfig, DefaultBootstrapConfig(), proxy.DefaultMeshExtensions(), pilotSAN)
 		if config == nil {
 			t.Fatal("Failed to generate config")
 		}
@@ -392,6 +394,68 @@ func TestProxyConfigControlPlaneAuth(t *testing.T) {
 	}
 }
 
+func TestWriteRuntime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	proxyConfig := proxy.DefaultProxyConfig()
+	proxyConfig.ConfigPath = dir
+	root := path.Join(dir, RuntimeDirectory, RuntimeSubdirectory)
+
+	// no runtime is written without mirror percentages and trace sampling
+	config := buildConfig(proxyConfig, DefaultBootstrapConfig(), proxy.DefaultMeshExtensions(), nil)
+	if err = config.writeRuntime(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("writeRuntime() => got runtime directory without runtime values: %v", err)
+	}
+
+	// the files of the runtime directory not written by the agent are kept
+	if err = os.MkdirAll(path.Join(root, "upstream"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(root, "upstream", "use_retry"), []byte("0"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	extensions := proxy.DefaultMeshExtensions()
+	extensions.MirrorPercentages = []int32{10, 25}
+	config = buildConfig(proxyConfig, DefaultBootstrapConfig(), extensions, nil)
+	if err = config.writeRuntime(dir); err != nil {
+		t.Fatal(err)
+	}
+	extensions.MirrorPercentages = []int32{25}
+	config = buildConfig(proxyConfig, DefaultBootstrapConfig(), extensions, nil)
+	if err = config.writeRuntime(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]string{
+		"mirror/percent_10":  "",
+		"mirror/percent_25":  "2500",
+		"upstream/use_retry": "0",
+	} {
+		got, err := ioutil.ReadFile(path.Join(root, file))
+		if want == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("writeRuntime() => got stale runtime file %s: %q, %v", file, got, err)
+			}
+			continue
+		}
+		if err != nil || string(got) != want {
+			t.Errorf("writeRuntime() => got runtime file %s: %q, %v, want %q", file, got, err, want)
+		}
+	}
+}
+
 /*
 var (
 	ingressCertFile = "testdata/tls.crt"
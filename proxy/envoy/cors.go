@@ -0,0 +1,80 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Functions related to the handling of cross-origin requests by Envoy.
+// The CORS policy is attached to the routes and enforced by the CORS filter.
+
+package envoy
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"istio.io/pilot/model"
+)
+
+const (
+	// CorsFilter is the name of the CORS HTTP filter
+	CorsFilter = "cors"
+)
+
+// buildCorsPolicy translates a CORS policy to the route configuration
+func buildCorsPolicy(cors *model.CorsPolicy) *CorsPolicy {
+	out := &CorsPolicy{
+		Enabled:          true,
+		AllowOrigin:      cors.AllowOrigin,
+		AllowMethods:     strings.Join(cors.AllowMethods, ", "),
+		AllowHeaders:     strings.Join(cors.AllowHeaders, ", "),
+		ExposeHeaders:    strings.Join(cors.ExposeHeaders, ", "),
+		AllowCredentials: cors.AllowCredentials,
+	}
+
+	// max age is validated to be whole seconds
+	if maxAge, err := time.ParseDuration(cors.MaxAge); err == nil {
+		out.MaxAge = strconv.Itoa(int(maxAge.Seconds()))
+	}
+
+	return out
+}
+
+// insertCorsFilter adds the CORS filter to the HTTP listener if any of the
+// routes in the route config has a CORS policy. The filter precedes all
+// filters except for the mixer filter so that the preflight requests are
+// answered by the proxy.
+func insertCorsFilter(listener *Listener, routeConfig *HTTPRouteConfig) {
+	if routeConfig == nil || !routeConfig.cors() {
+		return
+	}
+
+	for _, filter := range listener.Filters {
+		config, ok := filter.Config.(*HTTPFilterConfig)
+		if !ok {
+			continue
+		}
+
+		pos := 0
+		for pos < len(config.Filters) && config.Filters[pos].Name == MixerFilter {
+			pos++
+		}
+		filters := make([]HTTPFilter, 0, len(config.Filters)+1)
+		filters = append(filters, config.Filters[:pos]...)
+		filters = append(filters, HTTPFilter{
+			Type:   decoder,
+			Name:   CorsFilter,
+			Config: struct{}{},
+		})
+		config.Filters = append(filters, config.Filters[pos:]...)
+	}
+}
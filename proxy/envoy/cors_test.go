@@ -0,0 +1,73 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+func TestBuildCorsPolicy(t *testing.T) {
+	got := buildCorsPolicy(&model.CorsPolicy{
+		AllowOrigin:      []string{"https://example.com"},
+		AllowMethods:     []string{"GET", "POST"},
+		AllowHeaders:     []string{"x-tenant", "content-type"},
+		MaxAge:           "1h",
+		AllowCredentials: true,
+	})
+	want := &CorsPolicy{
+		Enabled:          true,
+		AllowOrigin:      []string{"https://example.com"},
+		AllowMethods:     "GET, POST",
+		AllowHeaders:     "x-tenant, content-type",
+		MaxAge:           "3600",
+		AllowCredentials: true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildCorsPolicy() => got %#v, want %#v", got, want)
+	}
+}
+
+func TestInsertCorsFilter(t *testing.T) {
+	mesh := makeMeshConfig()
+	routeConfig := &HTTPRouteConfig{VirtualHosts: []*VirtualHost{{
+		Name:   "world",
+		Routes: []*HTTPRoute{{Prefix: "/"}},
+	}}}
+
+	filterNames := func(listener *Listener) []string {
+		var out []string
+		for _, filter := range listener.Filters[0].Config.(*HTTPFilterConfig).Filters {
+			out = append(out, filter.Name)
+		}
+		return out
+	}
+
+	listener := buildHTTPListener(&mesh, proxy.Node{Type: proxy.Sidecar}, nil, routeConfig, WildcardAddress, 80, "80",
+		false, "")
+	if got, want := filterNames(listener), []string{MixerFilter, router}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildHTTPListener() => got filters %v, want %v", got, want)
+	}
+
+	routeConfig.VirtualHosts[0].Routes[0].Cors = &CorsPolicy{Enabled: true, AllowOrigin: []string{"*"}}
+	listener = buildHTTPListener(&mesh, proxy.Node{Type: proxy.Sidecar}, nil, routeConfig, WildcardAddress, 80, "80",
+		false, "")
+	if got, want := filterNames(listener), []string{MixerFilter, CorsFilter, router}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildHTTPListener() => got filters %v, want %v", got, want)
+	}
+}
@@ -403,11 +403,15 @@ func (ds *DiscoveryService) ListEndpoints(request *restful.Request, response *re
 			errorResponse(response, http.StatusServiceUnavailable, "EDS "+err.Error())
 			return
 		}
-		for _, ep := range endpoints {
-			hostArray = append(hostArray, &host{
-				Address: ep.Endpoint.Address,
-				Port:    ep.Endpoint.Port,
-			})
+		if weights := model.ParseServiceKeyWeights(request.PathParameter(ServiceKey)); weights != nil {
+			hostArray = append(hostArray, buildWeightedHosts(endpoints, weights)...)
+		} else {
+			for _, ep := range endpoints {
+				hostArray = append(hostArray, &host{
+					Address: ep.Endpoint.Address,
+					Port:    ep.Endpoint.Port,
+				})
+			}
 		}
 		if out, err = json.MarshalIndent(hosts{Hosts: hostArray}, " ", " "); err != nil {
 			errorResponse(response, http.StatusInternalServerError, "EDS "+err.Error())
@@ -418,6 +422,55 @@ func (ds *DiscoveryService) ListEndpoints(request *restful.Request, response *re
 	writeResponse(response, out)
 }
 
+// buildWeightedHosts assigns load balancing weights to the hosts so that the
+// hosts matching each label set receive the share of the traffic given by the
+// weight of the label set. A host belongs to the first label set it matches.
+// Hosts in the label sets with zero weight are omitted.
+func buildWeightedHosts(endpoints []*model.ServiceInstance, weights []model.WeightedLabels) []*host {
+	sets := make([]int, len(endpoints))
+	counts := make([]int, len(weights))
+	for i, ep := range endpoints {
+		sets[i] = -1
+		for j, weight := range weights {
+			if weight.Labels.SubsetOf(ep.Labels) {
+				sets[i] = j
+				counts[j]++
+				break
+			}
+		}
+	}
+
+	// the weight per host is the label set weight split evenly between its
+	// hosts, scaled so that the largest weight is 100
+	shares := make([]float64, len(weights))
+	max := 0.0
+	for j, weight := range weights {
+		if counts[j] > 0 {
+			shares[j] = float64(weight.Weight) / float64(counts[j])
+			if shares[j] > max {
+				max = shares[j]
+			}
+		}
+	}
+
+	out := make([]*host, 0, len(endpoints))
+	for i, ep := range endpoints {
+		if sets[i] < 0 || shares[sets[i]] == 0 {
+			continue
+		}
+		weight := int(shares[sets[i]]*100/max + 0.5)
+		if weight < 1 {
+			weight = 1
+		}
+		out = append(out, &host{
+			Address: ep.Endpoint.Address,
+			Port:    ep.Endpoint.Port,
+			Tags:    &tags{Weight: weight},
+		})
+	}
+	return out
+}
+
 func (ds *DiscoveryService) parseDiscoveryRequest(request *restful.Request) (proxy.Node, error) {
 	node := request.PathParameter(ServiceNode)
 	role, err := proxy.ParseServiceNode(node)
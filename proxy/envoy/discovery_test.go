@@ -777,3 +777,33 @@ func TestDiscoveryCache(t *testing.T) {
 		compareResponse(got, c.wantCache, t)
 	}
 }
+
+func TestBuildWeightedHosts(t *testing.T) {
+	endpoints := []*model.ServiceInstance{
+		mock.MakeInstance(mock.WorldService, mock.PortHTTP, 0),
+		mock.MakeInstance(mock.WorldService, mock.PortHTTP, 1),
+		mock.MakeInstance(mock.WorldService, mock.PortHTTP, 2),
+		mock.MakeInstance(mock.WorldService, mock.PortHTTP, 3),
+	}
+	// versions v0 and v1 share 75%, version v2 has 25%, and version v3 none
+	endpoints[1].Labels["version"] = "v0"
+	weights := []model.WeightedLabels{
+		{Labels: model.Labels{"version": "v0"}, Weight: 75},
+		{Labels: model.Labels{"version": "v2"}, Weight: 25},
+	}
+
+	hosts := buildWeightedHosts(endpoints, weights)
+	want := map[string]int{
+		endpoints[0].Endpoint.Address: 100,
+		endpoints[1].Endpoint.Address: 100,
+		endpoints[2].Endpoint.Address: 67,
+	}
+	if len(hosts) != len(want) {
+		t.Fatalf("buildWeightedHosts() => got %d hosts, want %d", len(hosts), len(want))
+	}
+	for _, host := range hosts {
+		if host.Tags == nil || host.Tags.Weight != want[host.Address] {
+			t.Errorf("buildWeightedHosts() => got host %#v, want weight %d", host, want[host.Address])
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Functions related to the graceful drain and the readiness of Envoy through
+// its admin API.
+
+package envoy
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// adminHealthCheckFail fails the health checks of the proxy
+	adminHealthCheckFail = "/healthcheck/fail"
+
+	// adminStats lists the statistics of the proxy
+	adminStats = "/stats"
+
+	// activeConnectionsStat is the suffix of the listener active connection
+	// statistics
+	activeConnectionsStat = ".downstream_cx_active"
+
+	// ldsUpdateSuccessStat and cdsUpdateSuccessStat count the successful
+	// listener and cluster discovery responses
+	ldsUpdateSuccessStat = "listener_manager.lds.update_success"
+	cdsUpdateSuccessStat = "cluster_manager.cds.update_success"
+)
+
+// adminClient calls the admin API of the local proxy
+var adminClient = &http.Client{Timeout: 5 * time.Second}
+
+func (proxy envoy) adminURL(path string) string {
+	return fmt.Sprintf("http://127.0.0.1:%d%s", proxy.config.ProxyAdminPort, path)
+}
+
+func (proxy envoy) adminPost(path string) error {
+	resp, err := adminClient.Post(proxy.adminURL(path), "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("admin %s returned status %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// Drain fails the health checks of the proxy so that the endpoint is removed
+// from the load balancers. The proxy keeps serving the active connections
+// until the termination.
+func (proxy envoy) Drain() error {
+	return proxy.adminPost(adminHealthCheckFail)
+}
+
+// adminStats returns the integer statistics of the proxy with the matching
+// names
+func (proxy envoy) adminStats(match func(string) bool) (map[string]int, error) {
+	resp, err := adminClient.Get(proxy.adminURL(adminStats))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin %s returned status %d", adminStats, resp.StatusCode)
+	}
+
+	stats := make(map[string]int)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// statistics are listed as "name: value"
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		if !match(name) {
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid statistic %q: %v", scanner.Text(), err)
+		}
+		stats[name] = value
+	}
+	return stats, scanner.Err()
+}
+
+// ActiveConnections sums the active connections of the proxy listeners,
+// excluding the admin listener
+func (proxy envoy) ActiveConnections() (int, error) {
+	stats, err := proxy.adminStats(func(name string) bool {
+		return strings.HasPrefix(name, "listener.") && !strings.HasPrefix(name, "listener.admin.") &&
+			strings.HasSuffix(name, activeConnectionsStat)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	active := 0
+	for _, value := range stats {
+		active += value
+	}
+	return active, nil
+}
+
+// Ready checks that the proxy has received its first listener and cluster
+// discovery responses. The proxy is not ready until both statistics are
+// reported.
+func (proxy envoy) Ready() error {
+	stats, err := proxy.adminStats(func(name string) bool {
+		return name == ldsUpdateSuccessStat || name == cdsUpdateSuccessStat
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{ldsUpdateSuccessStat, cdsUpdateSuccessStat} {
+		value, exists := stats[name]
+		if !exists {
+			return fmt.Errorf("%s is missing", name)
+		}
+		if value == 0 {
+			return fmt.Errorf("%s is %d", name, value)
+		}
+	}
+	return nil
+}
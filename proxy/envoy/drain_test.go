@@ -0,0 +1,110 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"istio.io/pilot/proxy"
+)
+
+func makeAdminProxy(t *testing.T, handler http.Handler) (envoy, func()) {
+	server := httptest.NewServer(handler)
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminPort, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := proxy.DefaultProxyConfig()
+	config.ProxyAdminPort = int32(adminPort)
+	return envoy{config: config}, server.Close
+}
+
+func TestDrain(t *testing.T) {
+	var calls []string
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminHealthCheckFail, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+	})
+	envoyProxy, stop := makeAdminProxy(t, mux)
+	defer stop()
+
+	if err := envoyProxy.Drain(); err != nil {
+		t.Errorf("Drain() => unexpected error %v", err)
+	}
+	if len(calls) != 1 || calls[0] != "POST "+adminHealthCheckFail {
+		t.Errorf("Drain() => got admin calls %v, want the health check failure", calls)
+	}
+
+	envoyProxy, stop = makeAdminProxy(t, http.NotFoundHandler())
+	defer stop()
+	if err := envoyProxy.Drain(); err == nil {
+		t.Error("Drain() => expected an error if the health checks cannot be failed")
+	}
+}
+
+func TestActiveConnections(t *testing.T) {
+	stats := "listener.admin.downstream_cx_active: 1\n" +
+		"listener.0.0.0.0_15001.downstream_cx_active: 3\n" +
+		"listener.0.0.0.0_15001.downstream_cx_total: 40\n" +
+		"listener.10.0.0.1_9080.downstream_cx_active: 2\n" +
+		"http.admin.downstream_cx_active: 1\n"
+	envoyProxy, stop := makeAdminProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, stats) // nolint: errcheck
+	}))
+	defer stop()
+
+	if active, err := envoyProxy.ActiveConnections(); err != nil || active != 5 {
+		t.Errorf("ActiveConnections() => got %d, %v, want 5", active, err)
+	}
+
+	stats = "listener.10.0.0.1_9080.downstream_cx_active: many\n"
+	if _, err := envoyProxy.ActiveConnections(); err == nil {
+		t.Error("ActiveConnections() => expected an error for an invalid statistic")
+	}
+}
+
+func TestReady(t *testing.T) {
+	stats := ""
+	envoyProxy, stop := makeAdminProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, stats) // nolint: errcheck
+	}))
+	defer stop()
+
+	cases := []struct {
+		stats string
+		ready bool
+	}{
+		{stats: ldsUpdateSuccessStat + ": 0\n" + cdsUpdateSuccessStat + ": 1\n", ready: false},
+		{stats: ldsUpdateSuccessStat + ": 1\n" + cdsUpdateSuccessStat + ": 0\n", ready: false},
+		{stats: ldsUpdateSuccessStat + ": 2\n" + cdsUpdateSuccessStat + ": 1\n", ready: true},
+		{stats: cdsUpdateSuccessStat + ": 1\n", ready: false},
+		{stats: ldsUpdateSuccessStat + ": 1\n", ready: false},
+	}
+	for _, c := range cases {
+		stats = c.stats
+		if err := envoyProxy.Ready(); (err == nil) != c.ready {
+			t.Errorf("Ready() with stats %q => got error %v, want ready %t", c.stats, err, c.ready)
+		}
+	}
+}
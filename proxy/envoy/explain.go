@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Functions to explain the routing decisions for a pair of source and
+// destination without generating the complete proxy configuration.
+
+package envoy
+
+import (
+	"istio.io/pilot/model"
+)
+
+// RouteExplanation describes the route rules and destination policies that
+// apply to the traffic from a source to a destination service port, together
+// with the Envoy routes they become.
+type RouteExplanation struct {
+	// Rules lists the route rules matching the source and the destination
+	// in the order of precedence
+	Rules []ExplainedRule
+
+	// Policies lists the destination policies applied to the clusters
+	// referenced by the routes
+	Policies []ExplainedPolicy
+
+	// Routes lists the Envoy routes in the order they are matched,
+	// including the default route if no rule catches all requests
+	Routes []*HTTPRoute
+}
+
+// ExplainedRule pairs a route rule with its Envoy route.
+type ExplainedRule struct {
+	Config model.Config
+
+	// Route is nil if the rule is shadowed by a preceding rule that matches
+	// all requests
+	Route *HTTPRoute
+}
+
+// ExplainedPolicy pairs an Envoy cluster with its destination policy.
+type ExplainedPolicy struct {
+	Cluster string
+	Config  model.Config
+}
+
+// ExplainRoutes computes the routing decisions for the source service
+// instances and the destination service port. It follows the same steps as
+// the outbound route generation for the source proxy.
+func ExplainRoutes(config model.IstioConfigStore, instances []*model.ServiceInstance,
+	service *model.Service, port *model.Port) *RouteExplanation {
+	out := &RouteExplanation{}
+
+	if port.Protocol.IsHTTP() {
+		rules := config.RouteRules(instances, service.Hostname)
+		model.SortRouteRules(rules)
+		catchAll := false
+		for _, rule := range rules {
+			explained := ExplainedRule{Config: rule}
+			if !catchAll {
+				explained.Route = buildHTTPRoute(rule, service, port)
+				catchAll = explained.Route.CatchAll()
+			}
+			out.Rules = append(out.Rules, explained)
+		}
+	}
+
+	out.Routes = buildDestinationHTTPRoutes(service, port, instances, config)
+
+	clusters := make(Clusters, 0)
+	for _, route := range out.Routes {
+		clusters = append(clusters, route.clusters...)
+	}
+	for _, cluster := range clusters.normalize() {
+		if !cluster.outbound {
+			continue
+		}
+		if policy := config.Policy(instances, cluster.hostname, cluster.tags); policy != nil {
+			out.Policies = append(out.Policies, ExplainedPolicy{
+				Cluster: cluster.ServiceName,
+				Config:  *policy,
+			})
+		}
+	}
+
+	return out
+}
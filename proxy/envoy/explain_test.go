@@ -0,0 +1,67 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestExplainRoutes(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	store := model.MakeIstioStore(registry)
+	instances := []*model.ServiceInstance{mock.MakeInstance(mock.HelloService, mock.PortHTTP, 0)}
+
+	out := ExplainRoutes(store, instances, mock.WorldService, mock.PortHTTP)
+	if len(out.Rules) != 0 || len(out.Policies) != 0 {
+		t.Errorf("ExplainRoutes() => got rules %v and policies %v, want none", out.Rules, out.Policies)
+	}
+	if len(out.Routes) != 1 || out.Routes[0].Decorator.Operation != "default-route" {
+		t.Errorf("ExplainRoutes() => got routes %v, want the default route", out.Routes)
+	}
+
+	addConfig(registry, weightedRouteRule, t)
+	addConfig(registry, cbPolicy, t)
+	out = ExplainRoutes(store, instances, mock.WorldService, mock.PortHTTP)
+	if len(out.Rules) != 1 || out.Rules[0].Config.Name != "weighted" || out.Rules[0].Route == nil {
+		t.Errorf("ExplainRoutes() => got rules %v, want the weighted rule", out.Rules)
+	}
+	if len(out.Routes) != 1 || out.Routes[0].WeightedClusters == nil {
+		t.Errorf("ExplainRoutes() => got routes %v, want a weighted route", out.Routes)
+	}
+	if len(out.Policies) != 1 || out.Policies[0].Config.Name != "circuit-breaker" {
+		t.Errorf("ExplainRoutes() => got policies %v, want the circuit breaker policy", out.Policies)
+	}
+
+	// the policy does not apply to other sources
+	out = ExplainRoutes(store, nil, mock.WorldService, mock.PortHTTP)
+	if len(out.Rules) != 1 || len(out.Policies) != 0 {
+		t.Errorf("ExplainRoutes() => got rules %v and policies %v, want the weighted rule only", out.Rules, out.Policies)
+	}
+
+	// rules with equal precedence are ordered by key, the catch-all timeout
+	// rule shadows the weighted rule
+	addConfig(registry, timeoutRouteRule, t)
+	out = ExplainRoutes(store, instances, mock.WorldService, mock.PortHTTP)
+	if len(out.Rules) != 2 || out.Rules[0].Config.Name != "timeout" || out.Rules[1].Route != nil {
+		t.Errorf("ExplainRoutes() => got rules %v, want the weighted rule shadowed", out.Rules)
+	}
+	if len(out.Routes) != 1 || out.Routes[0].TimeoutMS == 0 {
+		t.Errorf("ExplainRoutes() => got routes %v, want the timeout route", out.Routes)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Functions related to the failover of the proxy across the discovery
+// services. The discovery clusters point at the addresses of the highest
+// priority with a reachable address.
+
+package envoy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+)
+
+// DiscoveryAddress is an address of the discovery service with its priority
+type DiscoveryAddress struct {
+	// Address of the discovery service (e.g. istio-pilot:15003)
+	Address string
+
+	// Priority of the address, 0 being the highest. The addresses of a lower
+	// priority are used while none of the higher priorities is reachable.
+	Priority int
+}
+
+// discoveryProbeInterval is the delay between two successive probes of the
+// discovery addresses
+var discoveryProbeInterval = 5 * time.Second
+
+// discoveryFailoverProbes is the number of successive probes selecting
+// another group of addresses before the proxy fails over to it, so that a
+// flapping address does not reload the proxy on every probe
+var discoveryFailoverProbes = 3
+
+// ParseDiscoveryAddresses parses the comma separated discovery addresses
+// with an optional priority suffix (e.g. "pilot:15003,remote-pilot:15003=1")
+func ParseDiscoveryAddresses(value string) ([]DiscoveryAddress, error) {
+	var out []DiscoveryAddress
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		address := DiscoveryAddress{Address: entry}
+		if eq := strings.LastIndex(entry, "="); eq >= 0 {
+			priority, err := strconv.Atoi(entry[eq+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid priority of discovery address %q: %v", entry, err)
+			}
+			address = DiscoveryAddress{Address: entry[:eq], Priority: priority}
+		}
+		out = append(out, address)
+	}
+	return out, nil
+}
+
+// ValidateDiscoveryAddresses checks the discovery addresses and their
+// priorities
+func ValidateDiscoveryAddresses(addresses []DiscoveryAddress) (errs error) {
+	seen := make(map[string]bool)
+	for _, address := range addresses {
+		if err := model.ValidateProxyAddress(address.Address); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "invalid discovery address:"))
+		}
+		if address.Priority < 0 {
+			errs = multierror.Append(errs, fmt.Errorf("priority %d of discovery address %s must be non-negative",
+				address.Priority, address.Address))
+		}
+		if seen[address.Address] {
+			errs = multierror.Append(errs, fmt.Errorf("duplicate discovery address %s", address.Address))
+		}
+		seen[address.Address] = true
+	}
+	return
+}
+
+// groupDiscoveryAddresses groups the addresses by descending priority
+func groupDiscoveryAddresses(addresses []DiscoveryAddress) [][]string {
+	byPriority := make(map[int][]string)
+	priorities := make([]int, 0)
+	for _, address := range addresses {
+		if _, exists := byPriority[address.Priority]; !exists {
+			priorities = append(priorities, address.Priority)
+		}
+		byPriority[address.Priority] = append(byPriority[address.Priority], address.Address)
+	}
+	sort.Ints(priorities)
+
+	groups := make([][]string, 0, len(priorities))
+	for _, priority := range priorities {
+		groups = append(groups, byPriority[priority])
+	}
+	return groups
+}
+
+// discoveryFailover selects the group of discovery addresses of the highest
+// priority with a reachable address
+type discoveryFailover struct {
+	mutex  sync.Mutex
+	groups [][]string
+	active int
+
+	// candidate is the group other than the active group selected by the
+	// last probes, and streak is the number of these successive probes
+	candidate int
+	streak    int
+
+	// timeout bounds the probes, and follows the connect timeout of the
+	// reloaded proxy config
+	timeout time.Duration
+
+	// dial checks that an address is reachable within the timeout
+	dial func(address string, timeout time.Duration) error
+}
+
+func newDiscoveryFailover(addresses []DiscoveryAddress, timeout time.Duration) *discoveryFailover {
+	return &discoveryFailover{
+		groups:  groupDiscoveryAddresses(addresses),
+		timeout: timeout,
+		dial:    dialDiscoveryAddress,
+	}
+}
+
+// dialDiscoveryAddress opens and closes a TCP connection to the address
+func dialDiscoveryAddress(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// setTimeout updates the timeout of the probes
+func (f *discoveryFailover) setTimeout(timeout time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.timeout = timeout
+}
+
+// current returns the active discovery addresses
+func (f *discoveryFailover) current() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.groups[f.active]
+}
+
+// reachable returns the group of the highest priority with a reachable
+// address, or -1 if none is reachable. The addresses are dialed
+// concurrently, so that a probe lasts at most the timeout.
+func (f *discoveryFailover) reachable() int {
+	f.mutex.Lock()
+	timeout := f.timeout
+	f.mutex.Unlock()
+
+	results := make([][]bool, len(f.groups))
+	var wg sync.WaitGroup
+	for i, group := range f.groups {
+		results[i] = make([]bool, len(group))
+		for j, address := range group {
+			wg.Add(1)
+			go func(i, j int, address string) {
+				defer wg.Done()
+				if err := f.dial(address, timeout); err != nil {
+					glog.V(2).Infof("Discovery address %s is unreachable: %v", address, err)
+					return
+				}
+				results[i][j] = true
+			}(i, j, address)
+		}
+	}
+	wg.Wait()
+
+	for i, group := range results {
+		for _, ok := range group {
+			if ok {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// initialize activates the group of the highest priority with a reachable
+// address without waiting for successive probes, so that the proxy starts
+// with reachable addresses
+func (f *discoveryFailover) initialize() {
+	selected := f.reachable()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if selected < 0 {
+		glog.Warningf("None of the discovery addresses is reachable, starting with %v", f.groups[f.active])
+		return
+	}
+	f.active = selected
+}
+
+// probe activates the group of the highest priority with a reachable
+// address once the successive probes selected it discoveryFailoverProbes
+// times, and keeps the active group if none is reachable. It returns true
+// if the active group changed.
+func (f *discoveryFailover) probe() bool {
+	selected := f.reachable()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if selected < 0 {
+		glog.Warningf("None of the discovery addresses is reachable, keeping %v", f.groups[f.active])
+		f.streak = 0
+		return false
+	}
+	if selected == f.active {
+		f.streak = 0
+		return false
+	}
+	if selected != f.candidate {
+		f.candidate = selected
+		f.streak = 0
+	}
+	f.streak++
+	if f.streak < discoveryFailoverProbes {
+		glog.V(2).Infof("Discovery addresses %v selected by %d successive probes", f.groups[selected], f.streak)
+		return false
+	}
+	glog.Warningf("Discovery addresses %v are unreachable or recovered, failing over to %v",
+		f.groups[f.active], f.groups[selected])
+	f.active = selected
+	f.streak = 0
+	return true
+}
+
+// run probes the discovery addresses until the context is cancelled and
+// reloads the proxy when the active group changes
+func (f *discoveryFailover) run(ctx context.Context, reload func()) {
+	ticker := time.NewTicker(discoveryProbeInterval)
+	defer ticker.Stop()
+	for {
+		if f.probe() {
+			reload()
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// applyDiscoveryAddresses points the discovery clusters at the addresses
+func applyDiscoveryAddresses(config *Config, addresses []string) {
+	hosts := make([]Host, 0, len(addresses))
+	for _, address := range addresses {
+		hosts = append(hosts, Host{URL: "tcp://" + address})
+	}
+
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == RDSName || cluster.Name == LDSName {
+			cluster.Hosts = hosts
+		}
+	}
+	config.ClusterManager.SDS.Cluster.Hosts = hosts
+	config.ClusterManager.CDS.Cluster.Hosts = hosts
+}
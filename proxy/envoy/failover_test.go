@@ -0,0 +1,194 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestParseDiscoveryAddresses(t *testing.T) {
+	got, err := ParseDiscoveryAddresses("istio-pilot:15003, pilot-b:15003=0,remote-pilot:15003=2,")
+	if err != nil {
+		t.Fatalf("ParseDiscoveryAddresses() => unexpected error %v", err)
+	}
+	want := []DiscoveryAddress{
+		{Address: "istio-pilot:15003"},
+		{Address: "pilot-b:15003"},
+		{Address: "remote-pilot:15003", Priority: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseDiscoveryAddresses() => got %v, want %v", got, want)
+	}
+	if err := ValidateDiscoveryAddresses(got); err != nil {
+		t.Errorf("ValidateDiscoveryAddresses() => unexpected error %v", err)
+	}
+
+	if _, err := ParseDiscoveryAddresses("istio-pilot:15003=high"); err == nil {
+		t.Error("ParseDiscoveryAddresses() => expected an error for an invalid priority")
+	}
+
+	invalid := [][]DiscoveryAddress{
+		{{Address: "istio-pilot"}},
+		{{Address: "istio-pilot:15003", Priority: -1}},
+		{{Address: "istio-pilot:15003"}, {Address: "istio-pilot:15003", Priority: 1}},
+	}
+	for _, addresses := range invalid {
+		if err := ValidateDiscoveryAddresses(addresses); err == nil {
+			t.Errorf("ValidateDiscoveryAddresses(%v) => expected an error", addresses)
+		}
+	}
+}
+
+func TestDiscoveryFailover(t *testing.T) {
+	failover := newDiscoveryFailover([]DiscoveryAddress{
+		{Address: "remote-pilot:15003", Priority: 1},
+		{Address: "pilot-a:15003"},
+		{Address: "pilot-b:15003"},
+	}, 0)
+	var reachable map[string]bool
+	failover.dial = func(address string, _ time.Duration) error {
+		if !reachable[address] {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+
+	primary := []string{"pilot-a:15003", "pilot-b:15003"}
+	secondary := []string{"remote-pilot:15003"}
+
+	// the proxy starts with the secondary group without waiting for probes
+	reachable = map[string]bool{"remote-pilot:15003": true}
+	failover.initialize()
+	if got := failover.current(); !reflect.DeepEqual(got, secondary) {
+		t.Errorf("initialize() => got %v, want %v", got, secondary)
+	}
+
+	steps := []struct {
+		reachable []string
+		changed   bool
+		want      []string
+	}{
+		// the recovered primary group is activated after successive probes
+		{reachable: []string{"pilot-b:15003", "remote-pilot:15003"}, want: secondary},
+		{reachable: []string{"pilot-b:15003", "remote-pilot:15003"}, want: secondary},
+		{reachable: []string{"pilot-b:15003", "remote-pilot:15003"}, changed: true, want: primary},
+		// a flapping primary group does not fail over
+		{reachable: []string{"remote-pilot:15003"}, want: primary},
+		{reachable: []string{"pilot-a:15003"}, want: primary},
+		{reachable: []string{"remote-pilot:15003"}, want: primary},
+		{reachable: []string{"remote-pilot:15003"}, want: primary},
+		{reachable: []string{"remote-pilot:15003"}, changed: true, want: secondary},
+		// the active group is kept if no address is reachable
+		{reachable: nil, want: secondary},
+		{reachable: []string{"pilot-a:15003"}, want: secondary},
+		// an unreachable probe interrupts the successive probes
+		{reachable: nil, want: secondary},
+		{reachable: []string{"pilot-a:15003"}, want: secondary},
+		{reachable: []string{"pilot-a:15003"}, want: secondary},
+		{reachable: []string{"pilot-a:15003"}, changed: true, want: primary},
+	}
+	for i, step := range steps {
+		reachable = make(map[string]bool)
+		for _, address := range step.reachable {
+			reachable[address] = true
+		}
+		if changed := failover.probe(); changed != step.changed {
+			t.Errorf("step %d: probe() => got changed %t, want %t", i, changed, step.changed)
+		}
+		if got := failover.current(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("step %d: current() => got %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+func TestDiscoveryFailoverTimeout(t *testing.T) {
+	timeout := 100 * time.Millisecond
+	failover := newDiscoveryFailover([]DiscoveryAddress{
+		{Address: "remote-pilot:15003", Priority: 1},
+		{Address: "pilot-a:15003"},
+		{Address: "pilot-b:15003"},
+	}, timeout)
+	failover.dial = func(_ string, limit time.Duration) error {
+		time.Sleep(limit)
+		return errors.New("timeout")
+	}
+
+	// the unreachable addresses are dialed concurrently
+	start := time.Now()
+	if got := failover.reachable(); got != -1 {
+		t.Errorf("reachable() => got group %d, want none", got)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*timeout {
+		t.Errorf("reachable() => took %v, want less than %v", elapsed, 2*timeout)
+	}
+}
+
+func TestRenderDiscoveryAddresses(t *testing.T) {
+	config := proxy.DefaultProxyConfig()
+	bootstrap := DefaultBootstrapConfig()
+
+	// a single discovery address keeps the generated clusters
+	backend := NewBackend(proxy.BackendOptions{Config: config}, bootstrap)
+	if backend.Monitor != nil {
+		t.Error("NewBackend() => got a monitor without discovery addresses")
+	}
+
+	bootstrap.DiscoveryAddresses = []DiscoveryAddress{
+		{Address: "pilot-a:15003"},
+		{Address: "pilot-b:15003"},
+		{Address: "remote-pilot:15003", Priority: 1},
+	}
+	backend = NewBackend(proxy.BackendOptions{Config: config}, bootstrap)
+	if backend.Monitor == nil {
+		t.Error("NewBackend() => got no monitor of the discovery addresses")
+	}
+	rendered, err := backend.Renderer.Render(config, nil)
+	if err != nil {
+		t.Fatalf("Render() => unexpected error %v", err)
+	}
+
+	out := rendered.(*Config)
+	want := []Host{{URL: "tcp://pilot-a:15003"}, {URL: "tcp://pilot-b:15003"}}
+	clusters := []*Cluster{out.ClusterManager.SDS.Cluster, out.ClusterManager.CDS.Cluster}
+	for _, cluster := range out.ClusterManager.Clusters {
+		if cluster.Name == RDSName || cluster.Name == LDSName {
+			clusters = append(clusters, cluster)
+		}
+	}
+	if len(clusters) != 4 {
+		t.Fatalf("Render() => got %d discovery clusters, want 4", len(clusters))
+	}
+	for _, cluster := range clusters {
+		if !reflect.DeepEqual(cluster.Hosts, want) {
+			t.Errorf("Render() => got hosts %v of cluster %s, want %v", cluster.Hosts, cluster.Name, want)
+		}
+	}
+
+	// the probes follow the connect timeout of the reloaded proxy config
+	config.ConnectTimeout = ptypes.DurationProto(5 * time.Second)
+	if _, err = backend.Renderer.Render(config, nil); err != nil {
+		t.Fatalf("Render() => unexpected error %v", err)
+	}
+	if got := backend.Renderer.(renderer).failover.timeout; got != 5*time.Second {
+		t.Errorf("Render() => got probe timeout %v, want 5s", got)
+	}
+}
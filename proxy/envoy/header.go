@@ -72,3 +72,30 @@ func buildHeader(name string, match *proxyconfig.StringMatch) Header {
 
 	return header
 }
+
+// applyHeaderOperations appends the request header additions to the route in
+// a deterministic order and retains the response header operations for the
+// route config. The request header removals are rejected by the validation
+// since the v1 routes cannot carry them.
+func applyHeaderOperations(route *HTTPRoute, ops *model.HeaderOperations) {
+	if ops.Request != nil {
+		route.RequestHeadersToAdd = append(route.RequestHeadersToAdd, buildHeaderValues(ops.Request.Add)...)
+	}
+	if ops.Response != nil {
+		route.responseHeaders = append(route.responseHeaders, ops.Response)
+	}
+}
+
+func buildHeaderValues(headers map[string]string) []HeaderValue {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]HeaderValue, 0, len(names))
+	for _, name := range names {
+		out = append(out, HeaderValue{Key: name, Value: headers[name]})
+	}
+	return out
+}
@@ -19,7 +19,10 @@ import (
 	"testing"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
 	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
 )
 
 func TestHTTPMatch(t *testing.T) {
@@ -87,3 +90,88 @@ func TestHTTPMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyHeaderOperations(t *testing.T) {
+	route := &HTTPRoute{
+		RequestHeadersToAdd: []HeaderValue{{Key: "x-rule", Value: "route"}},
+	}
+	applyHeaderOperations(route, &model.HeaderOperations{
+		Request: &model.HeaderOperation{
+			Add: map[string]string{"x-tenant": "blue", "x-env": "prod"},
+		},
+	})
+
+	want := &HTTPRoute{
+		RequestHeadersToAdd: []HeaderValue{
+			{Key: "x-rule", Value: "route"},
+			{Key: "x-env", Value: "prod"},
+			{Key: "x-tenant", Value: "blue"},
+		},
+	}
+	if !reflect.DeepEqual(route, want) {
+		t.Errorf("applyHeaderOperations() => got %#v, want %#v", route, want)
+	}
+}
+
+func TestBuildRoutesHeaderOperations(t *testing.T) {
+	annotations := map[string]string{model.HeadersAnnotation: `{"request":{"add":{"x-tenant":"blue"}}}`}
+	want := []HeaderValue{{Key: "x-tenant", Value: "blue"}}
+
+	rule := model.Config{
+		ConfigMeta: model.ConfigMeta{Type: model.RouteRule.Type, Name: "headers", Namespace: "default",
+			Annotations: annotations},
+		Spec: &proxyconfig.RouteRule{Destination: &proxyconfig.IstioService{Name: "world"}},
+	}
+	route := buildHTTPRoute(rule, mock.WorldService, mock.PortHTTP)
+	if !reflect.DeepEqual(route.RequestHeadersToAdd, want) {
+		t.Errorf("buildHTTPRoute() => got headers %#v, want %#v", route.RequestHeadersToAdd, want)
+	}
+
+	mesh := proxy.DefaultMeshConfig()
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	ingress := model.Config{
+		ConfigMeta: model.ConfigMeta{Type: model.IngressRule.Type, Name: "headers", Namespace: "default",
+			Domain: "cluster.local", Annotations: annotations},
+		Spec: mock.ExampleIngressRule,
+	}
+	routes, _, err := buildIngressRoute(&mesh, nil, ingress, mock.Discovery, store)
+	if err != nil || len(routes) == 0 {
+		t.Fatalf("buildIngressRoute() => got routes %#v and error %v", routes, err)
+	}
+	for _, route := range routes {
+		if !reflect.DeepEqual(route.RequestHeadersToAdd, want) {
+			t.Errorf("buildIngressRoute() => got headers %#v, want %#v", route.RequestHeadersToAdd, want)
+		}
+	}
+}
+
+func TestBuildRDSRouteResponseHeaders(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	rules := map[string]string{
+		"hello": `{"response":{"add":{"x-env":"prod"},"remove":["x-internal"]}}`,
+		"world": `{"response":{"add":{"x-env":"prod"},"remove":["x-internal","x-debug"]}}`,
+	}
+	for name, ops := range rules {
+		rule := model.Config{
+			ConfigMeta: model.ConfigMeta{Type: model.RouteRule.Type, Name: name, Namespace: "default",
+				Domain: "cluster.local", Annotations: map[string]string{model.HeadersAnnotation: ops}},
+			Spec: &proxyconfig.RouteRule{Destination: &proxyconfig.IstioService{Name: name}},
+		}
+		if _, err := store.Create(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the response header operations of the routes apply once to the route config
+	routeConfig, err := buildRDSRoute(&mesh, mock.HelloProxyV0, "80", mock.Discovery, store)
+	if err != nil || routeConfig == nil {
+		t.Fatalf("buildRDSRoute() => got route config %#v and error %v", routeConfig, err)
+	}
+	if want := []HeaderValue{{Key: "x-env", Value: "prod"}}; !reflect.DeepEqual(routeConfig.ResponseHeadersToAdd, want) {
+		t.Errorf("buildRDSRoute() => got added response headers %#v, want %#v", routeConfig.ResponseHeadersToAdd, want)
+	}
+	if want := []string{"x-debug", "x-internal"}; !reflect.DeepEqual(routeConfig.ResponseHeadersToRemove, want) {
+		t.Errorf("buildRDSRoute() => got removed response headers %v, want %v", routeConfig.ResponseHeadersToRemove, want)
+	}
+}
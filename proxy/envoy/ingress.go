@@ -32,15 +32,18 @@ func buildIngressListeners(mesh *proxyconfig.MeshConfig,
 	discovery model.ServiceDiscovery,
 	config model.IstioConfigStore,
 	ingress proxy.Node) Listeners {
-	listeners := Listeners{
-		buildHTTPListener(mesh, ingress, instances, nil, WildcardAddress, 80, "80", true, EgressTraceOperation),
-	}
+	routes, secret := buildIngressRoutes(mesh, instances, discovery, config)
+	httpListener := buildHTTPListener(mesh, ingress, instances, nil, WildcardAddress, 80, "80", true, EgressTraceOperation)
+	insertCorsFilter(httpListener, routes[80])
+	insertRateLimitFilter(httpListener, routes[80])
+	listeners := Listeners{httpListener}
 
 	// lack of SNI in Envoy implies that TLS secrets are attached to listeners
 	// therefore, we should first check that TLS endpoint is needed before shipping TLS listener
-	_, secret := buildIngressRoutes(mesh, instances, discovery, config)
 	if secret != "" {
 		listener := buildHTTPListener(mesh, ingress, instances, nil, WildcardAddress, 443, "443", true, EgressTraceOperation)
+		insertCorsFilter(listener, routes[443])
+		insertRateLimitFilter(listener, routes[443])
 		listener.SSLContext = &SSLContext{
 			CertChainFile:  path.Join(proxy.IngressCertsPath, proxy.IngressCertFilename),
 			PrivateKeyFile: path.Join(proxy.IngressCertsPath, proxy.IngressKeyFilename),
@@ -118,6 +121,11 @@ func buildIngressRoutes(mesh *proxyconfig.MeshConfig,
 	}
 
 	configs := HTTPRouteConfigs{80: rc, 443: rcTLS}
+	services, err := discovery.Services()
+	if err != nil {
+		glog.Warningf("Skipping the mirrors of the ingress routes: %v", err)
+	}
+	applyMirrorPorts(configs, services)
 	return configs.normalize(), tlsAll
 }
 
@@ -169,6 +177,24 @@ func buildIngressRoute(mesh *proxyconfig.MeshConfig,
 		}
 	}
 
+	// header operations of the ingress rule follow those of the route rules
+	ops, err := model.RouteHeaderOperations(rule.ConfigMeta)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// CORS policy of the ingress rule overrides those of the route rules
+	cors, err := model.RouteCorsPolicy(rule.ConfigMeta)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// rate limits of the ingress rule are sent in addition to those of the route rules
+	limits, err := model.RouteRateLimits(rule.ConfigMeta)
+	if err != nil {
+		return nil, "", err
+	}
+
 	out := make([]*HTTPRoute, 0)
 	for _, route := range routes {
 		// enable mixer check on the route
@@ -176,6 +202,16 @@ func buildIngressRoute(mesh *proxyconfig.MeshConfig,
 			route.OpaqueConfig = buildMixerOpaqueConfig(!mesh.DisablePolicyChecks, true, service.Hostname)
 		}
 
+		if ops != nil {
+			applyHeaderOperations(route, ops)
+		}
+		if cors != nil {
+			route.Cors = buildCorsPolicy(cors)
+		}
+		if len(limits) > 0 {
+			route.RateLimits = append(route.RateLimits, buildRateLimits(limits)...)
+		}
+
 		if applied := route.CombinePathPrefix(ingressRoute.Path, ingressRoute.Prefix); applied != nil {
 			out = append(out, applied)
 		}
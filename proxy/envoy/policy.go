@@ -18,6 +18,10 @@
 package envoy
 
 import (
+	"time"
+
+	"github.com/golang/glog"
+
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/model"
 	"istio.io/pilot/proxy"
@@ -70,6 +74,17 @@ func applyClusterPolicy(cluster *Cluster,
 		}
 	}
 
+	// Ring hash load balancing overrides the load balancing name and requires
+	// the hash policy on the routes to the cluster
+	if hash, err := model.PolicyConsistentHash(policyConfig.ConfigMeta); err != nil {
+		glog.Warningf("Skipping consistent hash for destination policy %s: %v", policyConfig.Key(), err)
+	} else if hash != nil && cluster.Type != ClusterTypeOriginalDST {
+		cluster.LbType = LbTypeRingHash
+		if hash.MinimumRingSize > 0 {
+			cluster.RingHashLbConfig = &RingHashLbConfig{MinimumRingSize: hash.MinimumRingSize}
+		}
+	}
+
 	// Set up circuit breakers and outlier detection
 	if policy.CircuitBreaker != nil && policy.CircuitBreaker.GetSimpleCb() != nil {
 		cbconfig := policy.CircuitBreaker.GetSimpleCb()
@@ -87,22 +102,147 @@ func applyClusterPolicy(cluster *Cluster,
 		if cbconfig.HttpMaxPendingRequests > 0 {
 			cluster.CircuitBreaker.Default.MaxPendingRequests = int(cbconfig.HttpMaxPendingRequests)
 		}
-		//TODO: need to add max_retries as well. Currently it defaults to 3
 
 		cluster.OutlierDetection = &OutlierDetection{}
 
 		cluster.OutlierDetection.MaxEjectionPercent = 10
-		if cbconfig.SleepWindow.Seconds > 0 {
+		if cbconfig.SleepWindow != nil && cbconfig.SleepWindow.Seconds > 0 {
 			cluster.OutlierDetection.BaseEjectionTimeMS = protoDurationToMS(cbconfig.SleepWindow)
 		}
 		if cbconfig.HttpConsecutiveErrors > 0 {
 			cluster.OutlierDetection.ConsecutiveErrors = int(cbconfig.HttpConsecutiveErrors)
 		}
-		if cbconfig.HttpDetectionInterval.Seconds > 0 {
+		if cbconfig.HttpDetectionInterval != nil && cbconfig.HttpDetectionInterval.Seconds > 0 {
 			cluster.OutlierDetection.IntervalMS = protoDurationToMS(cbconfig.HttpDetectionInterval)
 		}
 		if cbconfig.HttpMaxEjectionPercent > 0 {
 			cluster.OutlierDetection.MaxEjectionPercent = int(cbconfig.HttpMaxEjectionPercent)
 		}
 	}
+
+	if outlier, err := model.PolicyOutlierDetection(policyConfig.ConfigMeta); err != nil {
+		glog.Warningf("Skipping outlier detection for destination policy %s: %v", policyConfig.Key(), err)
+	} else if outlier != nil {
+		applyOutlierDetection(cluster, outlier)
+	}
+
+	if pool, err := model.PolicyConnectionPool(policyConfig.ConfigMeta); err != nil {
+		glog.Warningf("Skipping connection pool for destination policy %s: %v", policyConfig.Key(), err)
+	} else if pool != nil {
+		applyConnectionPool(cluster, pool)
+	}
+
+	// Envoy defaults to 3 concurrent retries per cluster
+	if maxRetries, err := model.PolicyMaxRetries(policyConfig.ConfigMeta); err != nil {
+		glog.Warningf("Skipping max retries for destination policy %s: %v", policyConfig.Key(), err)
+	} else if maxRetries > 0 {
+		if cluster.CircuitBreaker == nil {
+			cluster.CircuitBreaker = &CircuitBreaker{}
+		}
+		cluster.CircuitBreaker.Default.MaxRetries = maxRetries
+	}
+}
+
+// applyOutlierDetection overrides the outlier detection of the cluster with
+// the settings of the destination policy. The durations are validated to be
+// whole milliseconds.
+func applyOutlierDetection(cluster *Cluster, outlier *model.OutlierDetection) {
+	if cluster.OutlierDetection == nil {
+		cluster.OutlierDetection = &OutlierDetection{}
+	}
+	detection := cluster.OutlierDetection
+
+	if interval, err := time.ParseDuration(outlier.Interval); err == nil {
+		detection.IntervalMS = int64(interval / time.Millisecond)
+	}
+	if ejection, err := time.ParseDuration(outlier.BaseEjectionTime); err == nil {
+		detection.BaseEjectionTimeMS = int64(ejection / time.Millisecond)
+	}
+	if outlier.MaxEjectionPercent > 0 {
+		detection.MaxEjectionPercent = outlier.MaxEjectionPercent
+	}
+	if outlier.ConsecutiveErrors > 0 {
+		detection.ConsecutiveErrors = outlier.ConsecutiveErrors
+	}
+	if outlier.ConsecutiveGatewayErrors > 0 {
+		detection.ConsecutiveGatewayFailure = outlier.ConsecutiveGatewayErrors
+		detection.EnforcingConsecutiveGatewayFailure = 100
+	}
+
+	// success rate ejection is enabled by default in Envoy
+	enforcing := 0
+	if rate := outlier.SuccessRate; rate != nil {
+		enforcing = 100
+		detection.SuccessRateMinimumHosts = rate.MinimumHosts
+		detection.SuccessRateRequestVolume = rate.RequestVolume
+		// Envoy divides the factor by 1000
+		detection.SuccessRateStdevFactor = int(rate.StdevFactor*1000 + 0.5)
+	}
+	detection.EnforcingSuccessRate = &enforcing
+}
+
+// applyConnectionPool overrides the upstream connection settings of the
+// cluster with the settings of the destination policy
+func applyConnectionPool(cluster *Cluster, pool *model.ConnectionPool) {
+	if cluster.port.Protocol.IsHTTP() {
+		switch pool.Protocol {
+		case model.UpstreamHTTP2:
+			cluster.Features = ClusterFeatureHTTP2
+		case model.UpstreamHTTP1:
+			if cluster.port.Protocol == model.ProtocolGRPC {
+				glog.Warningf("Skipping HTTP/1.1 upstream for gRPC cluster %s", cluster.Name)
+			} else {
+				cluster.Features = ""
+			}
+		}
+	}
+
+	if pool.MaxRequestsPerConnection > 0 {
+		cluster.MaxRequestsPerConnection = pool.MaxRequestsPerConnection
+	}
+	if pool.MaxPendingRequests > 0 {
+		if cluster.CircuitBreaker == nil {
+			cluster.CircuitBreaker = &CircuitBreaker{}
+		}
+		cluster.CircuitBreaker.Default.MaxPendingRequests = pool.MaxPendingRequests
+	}
+}
+
+// applyRouteHashPolicy sets the hash policy of the route from the destination
+// policy of the first route cluster that is load balanced by hash. Requests
+// copied to the shadow cluster do not affect the hash key. The policy applies
+// to the sidecar and the ingress routes to the mesh services, but not to the
+// egress routes since the original destination clusters are not load
+// balanced.
+func applyRouteHashPolicy(route *HTTPRoute,
+	instances []*model.ServiceInstance,
+	config model.IstioConfigStore) {
+	for _, cluster := range route.clusters {
+		if !cluster.outbound || cluster.Type == ClusterTypeOriginalDST {
+			continue
+		}
+		if route.Shadow != nil && route.Shadow.Cluster == cluster.Name && route.Cluster != cluster.Name {
+			continue
+		}
+
+		policyConfig := config.Policy(instances, cluster.hostname, cluster.tags)
+		if policyConfig == nil {
+			continue
+		}
+		hash, err := model.PolicyConsistentHash(policyConfig.ConfigMeta)
+		if err != nil || hash == nil {
+			continue
+		}
+
+		route.HashPolicy = buildHashPolicy(hash)
+		return
+	}
+}
+
+// buildHashPolicy translates the hash header of the destination policy
+func buildHashPolicy(hash *model.ConsistentHash) *HashPolicy {
+	if hash.Header == "" {
+		return nil
+	}
+	return &HashPolicy{HeaderName: hash.Header}
 }
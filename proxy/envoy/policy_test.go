@@ -0,0 +1,214 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func addPolicy(t *testing.T, store model.ConfigStore, name string, annotations map[string]string,
+	policy *proxyconfig.DestinationPolicy) {
+	if _, err := store.Create(model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:        model.DestinationPolicy.Type,
+			Name:        name,
+			Namespace:   "default",
+			Domain:      "cluster.local",
+			Annotations: annotations,
+		},
+		Spec: policy,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyClusterPolicyMaxRetries(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	store := model.MakeIstioStore(registry)
+	mesh := proxy.DefaultMeshConfig()
+
+	addPolicy(t, registry, "retries", map[string]string{model.MaxRetriesAnnotation: "10"},
+		&proxyconfig.DestinationPolicy{
+			Destination: &proxyconfig.IstioService{Name: "world", Labels: map[string]string{"version": "v1"}},
+		})
+	addPolicy(t, registry, "cb", map[string]string{model.MaxRetriesAnnotation: "5"},
+		&proxyconfig.DestinationPolicy{
+			Destination: &proxyconfig.IstioService{Name: "world", Labels: map[string]string{"version": "v0"}},
+			CircuitBreaker: &proxyconfig.CircuitBreaker{
+				CbPolicy: &proxyconfig.CircuitBreaker_SimpleCb{
+					SimpleCb: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{MaxConnections: 100},
+				},
+			},
+		})
+
+	cluster := buildOutboundCluster(mock.WorldService.Hostname, mock.PortHTTP, model.Labels{"version": "v1"})
+	applyClusterPolicy(cluster, nil, store, &mesh, mock.Discovery)
+	if cluster.CircuitBreaker == nil || cluster.CircuitBreaker.Default.MaxRetries != 10 {
+		t.Errorf("applyClusterPolicy() => got circuit breaker %#v, want max retries 10", cluster.CircuitBreaker)
+	}
+
+	cluster = buildOutboundCluster(mock.WorldService.Hostname, mock.PortHTTP, model.Labels{"version": "v0"})
+	applyClusterPolicy(cluster, nil, store, &mesh, mock.Discovery)
+	if cluster.CircuitBreaker == nil || cluster.CircuitBreaker.Default.MaxRetries != 5 ||
+		cluster.CircuitBreaker.Default.MaxConnections != 100 {
+		t.Errorf("applyClusterPolicy() => got circuit breaker %#v, want max retries 5 and max connections 100",
+			cluster.CircuitBreaker)
+	}
+
+	cluster = buildOutboundCluster(mock.WorldService.Hostname, mock.PortHTTP, nil)
+	applyClusterPolicy(cluster, nil, store, &mesh, mock.Discovery)
+	if cluster.CircuitBreaker != nil {
+		t.Errorf("applyClusterPolicy() => got circuit breaker %#v, want none", cluster.CircuitBreaker)
+	}
+}
+
+func TestApplyClusterPolicyOutlierDetection(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	store := model.MakeIstioStore(registry)
+	mesh := proxy.DefaultMeshConfig()
+
+	addPolicy(t, registry, "outlier", map[string]string{model.OutlierDetectionAnnotation: `{
+		"interval": "5s", "baseEjectionTime": "30s", "maxEjectionPercent": 50,
+		"consecutiveGatewayErrors": 3, "successRate": {"minimumHosts": 3, "stdevFactor": 1.9}}`},
+		&proxyconfig.DestinationPolicy{
+			Destination: &proxyconfig.IstioService{Name: "world", Labels: map[string]string{"version": "v1"}},
+		})
+	addPolicy(t, registry, "cb", map[string]string{model.OutlierDetectionAnnotation: `{"maxEjectionPercent": 100}`},
+		&proxyconfig.DestinationPolicy{
+			Destination: &proxyconfig.IstioService{Name: "world", Labels: map[string]string{"version": "v0"}},
+			CircuitBreaker: &proxyconfig.CircuitBreaker{
+				CbPolicy: &proxyconfig.CircuitBreaker_SimpleCb{
+					SimpleCb: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{HttpConsecutiveErrors: 7},
+				},
+			},
+		})
+
+	enforcing, disabled := 100, 0
+	cluster := buildOutboundCluster(mock.WorldService.Hostname, mock.PortHTTP, model.Labels{"version": "v1"})
+	applyClusterPolicy(cluster, nil, store, &mesh, mock.Discovery)
+	want := &OutlierDetection{
+		IntervalMS:                         5000,
+		BaseEjectionTimeMS:                 30000,
+		MaxEjectionPercent:                 50,
+		ConsecutiveGatewayFailure:          3,
+		EnforcingConsecutiveGatewayFailure: 100,
+		EnforcingSuccessRate:               &enforcing,
+		SuccessRateMinimumHosts:            3,
+		SuccessRateStdevFactor:             1900,
+	}
+	if !reflect.DeepEqual(cluster.OutlierDetection, want) || cluster.CircuitBreaker != nil {
+		t.Errorf("applyClusterPolicy() => got outlier detection %#v, want %#v", cluster.OutlierDetection, want)
+	}
+
+	// the settings override those derived from the circuit breaker
+	cluster = buildOutboundCluster(mock.WorldService.Hostname, mock.PortHTTP, model.Labels{"version": "v0"})
+	applyClusterPolicy(cluster, nil, store, &mesh, mock.Discovery)
+	want = &OutlierDetection{
+		ConsecutiveErrors:    7,
+		MaxEjectionPercent:   100,
+		EnforcingSuccessRate: &disabled,
+	}
+	if !reflect.DeepEqual(cluster.OutlierDetection, want) {
+		t.Errorf("applyClusterPolicy() => got outlier detection %#v, want %#v", cluster.OutlierDetection, want)
+	}
+}
+
+func TestApplyConsistentHash(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	store := model.MakeIstioStore(registry)
+	mesh := proxy.DefaultMeshConfig()
+
+	addPolicy(t, registry, "sticky", map[string]string{
+		model.ConsistentHashAnnotation: `{"header": "x-user", "minimumRingSize": 1024}`},
+		&proxyconfig.DestinationPolicy{
+			Destination: &proxyconfig.IstioService{Name: "world", Labels: map[string]string{"version": "v1"}},
+			LoadBalancing: &proxyconfig.LoadBalancing{
+				LbPolicy: &proxyconfig.LoadBalancing_Name{Name: proxyconfig.LoadBalancing_RANDOM},
+			},
+		})
+
+	cluster := buildOutboundCluster(mock.WorldService.Hostname, mock.PortHTTP, model.Labels{"version": "v1"})
+	applyClusterPolicy(cluster, nil, store, &mesh, mock.Discovery)
+	if cluster.LbType != LbTypeRingHash || cluster.RingHashLbConfig == nil ||
+		cluster.RingHashLbConfig.MinimumRingSize != 1024 {
+		t.Errorf("applyClusterPolicy() => got lb type %q and config %#v, want ring hash with minimum ring size 1024",
+			cluster.LbType, cluster.RingHashLbConfig)
+	}
+
+	route := buildDefaultRoute(cluster)
+	applyRouteHashPolicy(route, nil, store)
+	if want := (&HashPolicy{HeaderName: "x-user"}); !reflect.DeepEqual(route.HashPolicy, want) {
+		t.Errorf("applyRouteHashPolicy() => got %#v, want %#v", route.HashPolicy, want)
+	}
+
+	route = buildDefaultRoute(buildOutboundCluster(mock.WorldService.Hostname, mock.PortHTTP, nil))
+	applyRouteHashPolicy(route, nil, store)
+	if route.HashPolicy != nil {
+		t.Errorf("applyRouteHashPolicy() => got %#v, want none", route.HashPolicy)
+	}
+}
+
+func TestBuildHashPolicy(t *testing.T) {
+	cases := []struct {
+		hash *model.ConsistentHash
+		want *HashPolicy
+	}{
+		{hash: &model.ConsistentHash{Header: "x-user"}, want: &HashPolicy{HeaderName: "x-user"}},
+		{hash: &model.ConsistentHash{}, want: nil},
+	}
+	for _, c := range cases {
+		if got := buildHashPolicy(c.hash); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("buildHashPolicy(%#v) => got %#v, want %#v", c.hash, got, c.want)
+		}
+	}
+}
+
+func TestApplyConnectionPool(t *testing.T) {
+	grpc := &model.Port{Name: "grpc", Port: 90, Protocol: model.ProtocolGRPC}
+	tcp := &model.Port{Name: "tcp", Port: 91, Protocol: model.ProtocolTCP}
+
+	cluster := buildOutboundCluster(mock.WorldService.Hostname, mock.PortHTTP, nil)
+	applyConnectionPool(cluster, &model.ConnectionPool{
+		Protocol:           model.UpstreamHTTP2,
+		MaxPendingRequests: 100,
+	})
+	if cluster.Features != ClusterFeatureHTTP2 {
+		t.Errorf("applyConnectionPool() => got features %q, want HTTP/2", cluster.Features)
+	}
+	if cluster.CircuitBreaker == nil || cluster.CircuitBreaker.Default.MaxPendingRequests != 100 {
+		t.Errorf("applyConnectionPool() => got circuit breaker %#v, want max pending requests 100", cluster.CircuitBreaker)
+	}
+
+	// gRPC requires HTTP/2
+	cluster = buildOutboundCluster(mock.WorldService.Hostname, grpc, nil)
+	applyConnectionPool(cluster, &model.ConnectionPool{Protocol: model.UpstreamHTTP1})
+	if cluster.Features != ClusterFeatureHTTP2 {
+		t.Errorf("applyConnectionPool() => got features %q for gRPC, want HTTP/2", cluster.Features)
+	}
+
+	// the upstream protocol does not apply to TCP ports
+	cluster = buildOutboundCluster(mock.WorldService.Hostname, tcp, nil)
+	applyConnectionPool(cluster, &model.ConnectionPool{Protocol: model.UpstreamHTTP2, MaxRequestsPerConnection: 1})
+	if cluster.Features != "" || cluster.CircuitBreaker != nil || cluster.MaxRequestsPerConnection != 1 {
+		t.Errorf("applyConnectionPool() => got cluster %#v, want max requests per connection only", cluster)
+	}
+}
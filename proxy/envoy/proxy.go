@@ -0,0 +1,150 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+const (
+	// EpochFileTemplate is a template for the root config JSON
+	EpochFileTemplate = "envoy-rev%d.json"
+)
+
+func configFile(config string, epoch int) string {
+	return path.Join(config, fmt.Sprintf(EpochFileTemplate, epoch))
+}
+
+type envoy struct {
+	config    proxyconfig.ProxyConfig
+	node      string
+	extraArgs []string
+}
+
+// NewProxy creates an instance of the proxy control commands
+func NewProxy(config proxyconfig.ProxyConfig, node string) proxy.Proxy {
+	// inject tracing flag for higher levels
+	var args []string
+	if glog.V(4) {
+		args = append(args, "-l", "trace")
+	} else if glog.V(3) {
+		args = append(args, "-l", "debug")
+	}
+
+	return envoy{
+		config:    config,
+		node:      node,
+		extraArgs: args,
+	}
+}
+
+func (proxy envoy) args(fname string, epoch int) []string {
+	startupArgs := []string{"-c", fname,
+		"--restart-epoch", fmt.Sprint(epoch),
+		"--drain-time-s", fmt.Sprint(int(convertDuration(proxy.config.DrainDuration) / time.Second)),
+		"--parent-shutdown-time-s", fmt.Sprint(int(convertDuration(proxy.config.ParentShutdownDuration) / time.Second)),
+		"--service-cluster", proxy.config.ServiceCluster,
+		"--service-node", proxy.node,
+	}
+
+	startupArgs = append(startupArgs, proxy.extraArgs...)
+
+	if len(proxy.config.AvailabilityZone) > 0 {
+		startupArgs = append(startupArgs, []string{"--service-zone", proxy.config.AvailabilityZone}...)
+	}
+
+	return startupArgs
+}
+
+func (proxy envoy) Run(config interface{}, epoch int, abort <-chan error) error {
+	envoyConfig, ok := config.(*Config)
+	if !ok {
+		return fmt.Errorf("unexpected config type: %#v", config)
+	}
+
+	var fname string
+	// Note: the cert checking still works, the generated file is updated if certs are changed.
+	// We just don't save the generated file, but use a custom one instead. Pilot will keep
+	// monitoring the certs and restart if the content of the certs changes.
+	if len(proxy.config.CustomConfigFile) > 0 {
+		// there is a custom configuration. Don't write our own config - but keep watching the certs.
+		fname = proxy.config.CustomConfigFile
+	} else {
+		// create parent directories if necessary
+		if err := os.MkdirAll(proxy.config.ConfigPath, 0700); err != nil {
+			return multierror.Prefix(err, "failed to create directory for proxy configuration")
+		}
+
+		if err := envoyConfig.writeRuntime(proxy.config.ConfigPath); err != nil {
+			return err
+		}
+
+		// attempt to write file
+		fname = configFile(proxy.config.ConfigPath, epoch)
+		if err := envoyConfig.WriteFile(fname); err != nil {
+			return err
+		}
+	}
+
+	// spin up a new Envoy process
+	args := proxy.args(fname, epoch)
+
+	glog.V(2).Infof("Envoy command: %v", args)
+
+	/* #nosec */
+	cmd := exec.Command(proxy.config.BinaryPath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-abort:
+		glog.Warningf("Aborting epoch %d", epoch)
+		if errKill := cmd.Process.Kill(); errKill != nil {
+			glog.Warningf("killing epoch %d caused an error %v", epoch, errKill)
+		}
+		return err
+	case err := <-done:
+		return err
+	}
+}
+
+func (proxy envoy) Cleanup(epoch int) {
+	path := configFile(proxy.config.ConfigPath, epoch)
+	if err := os.Remove(path); err != nil {
+		glog.Warningf("Failed to delete config file %s for %d, %v", path, epoch, err)
+	}
+}
+
+func (proxy envoy) Panic(_ interface{}) {
+	glog.Fatal("cannot start the proxy with the desired configuration")
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestEnvoyArgs(t *testing.T) {
+	config := proxy.DefaultProxyConfig()
+	config.ServiceCluster = "my-cluster"
+	config.AvailabilityZone = "my-zone"
+
+	test := envoy{config: config, node: "my-node"}
+	testProxy := NewProxy(config, "my-node")
+	if !reflect.DeepEqual(testProxy, test) {
+		t.Errorf("unexpected struct got\n%v\nwant\n%v", testProxy, test)
+	}
+
+	got := test.args("test.json", 5)
+	want := []string{
+		"-c", "test.json",
+		"--restart-epoch", "5",
+		"--drain-time-s", "2",
+		"--parent-shutdown-time-s", "3",
+		"--service-cluster", "my-cluster",
+		"--service-node", "my-node",
+		"--service-zone", "my-zone",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("envoyArgs() => got %v, want %v", got, want)
+	}
+}
+
+func TestEnvoyRun(t *testing.T) {
+	config := proxy.DefaultProxyConfig()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.BinaryPath = path.Join(dir, "envoy")
+	config.ConfigPath = "tmp"
+
+	envoyConfig := buildConfig(config, DefaultBootstrapConfig(), proxy.DefaultMeshExtensions(), nil)
+	proxy := envoy{config: config, node: "my-node", extraArgs: []string{"--mode", "validate"}}
+	abortCh := make(chan error, 1)
+
+	if err = proxy.Run(nil, 0, abortCh); err == nil {
+		t.Error("expected error on nil config")
+	}
+
+	if err = proxy.Run(envoyConfig, 0, abortCh); err != nil {
+		t.Error(err)
+	}
+
+	proxy.Cleanup(0)
+
+	badConfig := config
+	badConfig.ConfigPath = ""
+	proxy.config = badConfig
+
+	if err = proxy.Run(envoyConfig, 0, abortCh); err == nil {
+		t.Errorf("expected error on bad config path")
+	}
+}
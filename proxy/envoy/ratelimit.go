@@ -0,0 +1,140 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Functions related to the global rate limiting of HTTP requests by Envoy.
+// The descriptors are attached to the routes and sent by the rate limit
+// filter to the rate limit service configured in the bootstrap.
+
+package envoy
+
+import (
+	"github.com/golang/protobuf/ptypes/duration"
+
+	"istio.io/pilot/model"
+)
+
+const (
+	// RateLimitFilter is the name of the rate limit HTTP filter
+	RateLimitFilter = "rate_limit"
+
+	// RateLimitCluster is the name of the cluster of the rate limit service
+	RateLimitCluster = "rate_limit"
+
+	// RateLimitDomain is the domain of the descriptors sent by the proxies
+	RateLimitDomain = "istio"
+
+	// rate limit action types
+	rateLimitSourceCluster  = "source_cluster"
+	rateLimitRequestHeaders = "request_headers"
+	rateLimitRemoteAddress  = "remote_address"
+	rateLimitGenericKey     = "generic_key"
+
+	// pathHeader is the pseudo-header of the request path
+	pathHeader = ":path"
+
+	// pathDescriptorKey is the descriptor key of the request path entry
+	pathDescriptorKey = "path"
+)
+
+// rateLimitFilterConfig definition
+type rateLimitFilterConfig struct {
+	Domain string `json:"domain"`
+}
+
+// buildRateLimits translates the rate limit descriptors to the route configuration
+func buildRateLimits(limits []model.RateLimit) []*RateLimit {
+	out := make([]*RateLimit, 0, len(limits))
+	for _, limit := range limits {
+		actions := make([]RateLimitAction, 0, len(limit.Actions))
+		for _, action := range limit.Actions {
+			actions = append(actions, buildRateLimitAction(action))
+		}
+		out = append(out, &RateLimit{Actions: actions})
+	}
+	return out
+}
+
+func buildRateLimitAction(action model.RateLimitAction) RateLimitAction {
+	switch {
+	case action.Header != nil:
+		return RateLimitAction{
+			Type:          rateLimitRequestHeaders,
+			HeaderName:    action.Header.Name,
+			DescriptorKey: action.Header.DescriptorKey,
+		}
+	case action.SourceService:
+		// the service cluster of the proxy is the source service
+		return RateLimitAction{Type: rateLimitSourceCluster}
+	case action.Path:
+		return RateLimitAction{
+			Type:          rateLimitRequestHeaders,
+			HeaderName:    pathHeader,
+			DescriptorKey: pathDescriptorKey,
+		}
+	case action.RemoteAddress:
+		return RateLimitAction{Type: rateLimitRemoteAddress}
+	default:
+		return RateLimitAction{
+			Type:            rateLimitGenericKey,
+			DescriptorValue: action.GenericKey,
+		}
+	}
+}
+
+// insertRateLimitFilter adds the rate limit filter to the HTTP listener if any
+// of the routes in the route config has rate limits. The filter immediately
+// precedes the router filter so that the requests rejected by the other
+// filters are not counted.
+func insertRateLimitFilter(listener *Listener, routeConfig *HTTPRouteConfig) {
+	if routeConfig == nil || !routeConfig.rateLimits() {
+		return
+	}
+
+	for _, filter := range listener.Filters {
+		config, ok := filter.Config.(*HTTPFilterConfig)
+		if !ok {
+			continue
+		}
+
+		pos := len(config.Filters)
+		for pos > 0 && config.Filters[pos-1].Name == router {
+			pos--
+		}
+		filters := make([]HTTPFilter, 0, len(config.Filters)+1)
+		filters = append(filters, config.Filters[:pos]...)
+		filters = append(filters, HTTPFilter{
+			Type:   decoder,
+			Name:   RateLimitFilter,
+			Config: rateLimitFilterConfig{Domain: RateLimitDomain},
+		})
+		config.Filters = append(filters, config.Filters[pos:]...)
+	}
+}
+
+// buildRateLimitCluster creates the cluster of the rate limit service, which
+// accepts gRPC requests
+func buildRateLimitCluster(address string, timeout *duration.Duration) *Cluster {
+	cluster := buildCluster(address, RateLimitCluster, timeout)
+	cluster.Features = ClusterFeatureHTTP2
+	return cluster
+}
+
+// buildRateLimitService configures the proxy to call the rate limit service
+// over gRPC
+func buildRateLimitService() *RateLimitService {
+	return &RateLimitService{
+		Type:   "grpc_service",
+		Config: RateLimitServiceConfig{ClusterName: RateLimitCluster},
+	}
+}
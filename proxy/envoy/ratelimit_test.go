@@ -0,0 +1,150 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestBuildRateLimits(t *testing.T) {
+	got := buildRateLimits([]model.RateLimit{
+		{Actions: []model.RateLimitAction{
+			{SourceService: true},
+			{Header: &model.HeaderDescriptor{Name: "x-api-key", DescriptorKey: "key"}},
+		}},
+		{Actions: []model.RateLimitAction{{Path: true}, {RemoteAddress: true}, {GenericKey: "reviews"}}},
+	})
+	want := []*RateLimit{
+		{Actions: []RateLimitAction{
+			{Type: rateLimitSourceCluster},
+			{Type: rateLimitRequestHeaders, HeaderName: "x-api-key", DescriptorKey: "key"},
+		}},
+		{Actions: []RateLimitAction{
+			{Type: rateLimitRequestHeaders, HeaderName: pathHeader, DescriptorKey: pathDescriptorKey},
+			{Type: rateLimitRemoteAddress},
+			{Type: rateLimitGenericKey, DescriptorValue: "reviews"},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildRateLimits() => got %#v, want %#v", got, want)
+	}
+}
+
+func TestInsertRateLimitFilter(t *testing.T) {
+	mesh := makeMeshConfig()
+	routeConfig := &HTTPRouteConfig{VirtualHosts: []*VirtualHost{{
+		Name:   "world",
+		Routes: []*HTTPRoute{{Prefix: "/"}},
+	}}}
+
+	filterNames := func(listener *Listener) []string {
+		var out []string
+		for _, filter := range listener.Filters[0].Config.(*HTTPFilterConfig).Filters {
+			out = append(out, filter.Name)
+		}
+		return out
+	}
+
+	listener := buildHTTPListener(&mesh, proxy.Node{Type: proxy.Sidecar}, nil, routeConfig, WildcardAddress, 80, "80",
+		false, "")
+	if got, want := filterNames(listener), []string{MixerFilter, router}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildHTTPListener() => got filters %v, want %v", got, want)
+	}
+
+	routeConfig.VirtualHosts[0].Routes[0].RateLimits = []*RateLimit{{
+		Actions: []RateLimitAction{{Type: rateLimitRemoteAddress}},
+	}}
+	routeConfig.VirtualHosts[0].Routes[0].Cors = &CorsPolicy{Enabled: true, AllowOrigin: []string{"*"}}
+	listener = buildHTTPListener(&mesh, proxy.Node{Type: proxy.Sidecar}, nil, routeConfig, WildcardAddress, 80, "80",
+		false, "")
+	want := []string{MixerFilter, CorsFilter, RateLimitFilter, router}
+	if got := filterNames(listener); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildHTTPListener() => got filters %v, want %v", got, want)
+	}
+}
+
+func TestBuildConfigRateLimitService(t *testing.T) {
+	proxyConfig := proxy.DefaultProxyConfig()
+	config := buildConfig(proxyConfig, DefaultBootstrapConfig(), proxy.DefaultMeshExtensions(), nil)
+	if config.RateLimitService != nil {
+		t.Errorf("buildConfig() => got rate limit service %#v without an address", config.RateLimitService)
+	}
+
+	bootstrap := DefaultBootstrapConfig()
+	bootstrap.RateLimitAddress = "ratelimit:8081"
+	config = buildConfig(proxyConfig, bootstrap, proxy.DefaultMeshExtensions(), nil)
+	if config.RateLimitService == nil || config.RateLimitService.Config.ClusterName != RateLimitCluster {
+		t.Errorf("buildConfig() => got rate limit service %#v, want cluster %s", config.RateLimitService, RateLimitCluster)
+	}
+	found := false
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == RateLimitCluster {
+			found = cluster.Features == ClusterFeatureHTTP2
+		}
+	}
+	if !found {
+		t.Errorf("buildConfig() => missing HTTP/2 cluster %s", RateLimitCluster)
+	}
+}
+
+func TestBuildIngressRouteRateLimits(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	store := model.MakeIstioStore(memory.Make(model.IstioConfigTypes))
+	ingress := model.Config{
+		ConfigMeta: model.ConfigMeta{Type: model.IngressRule.Type, Name: "limited", Namespace: "default",
+			Domain:      "cluster.local",
+			Annotations: map[string]string{model.RateLimitsAnnotation: `[{"actions":[{"remoteAddress":true}]}]`}},
+		Spec: mock.ExampleIngressRule,
+	}
+	routes, _, err := buildIngressRoute(&mesh, nil, ingress, mock.Discovery, store)
+	if err != nil || len(routes) == 0 {
+		t.Fatalf("buildIngressRoute() => got routes %#v and error %v", routes, err)
+	}
+	want := []*RateLimit{{Actions: []RateLimitAction{{Type: rateLimitRemoteAddress}}}}
+	for _, route := range routes {
+		if !reflect.DeepEqual(route.RateLimits, want) {
+			t.Errorf("buildIngressRoute() => got rate limits %#v, want %#v", route.RateLimits, want)
+		}
+	}
+
+	// the rate limit filter is inserted in the ingress listener with the limited routes
+	listeners := buildIngressListeners(&mesh, nil, mock.Discovery, store, proxy.Node{Type: proxy.Ingress})
+	if len(listeners) == 0 {
+		t.Fatal("buildIngressListeners() => got no listeners")
+	}
+	found := false
+	for _, filter := range listeners[0].Filters[0].Config.(*HTTPFilterConfig).Filters {
+		found = found || filter.Name == RateLimitFilter
+	}
+	if found {
+		t.Error("buildIngressListeners() => got rate limit filter without ingress rules")
+	}
+	if _, err = store.Create(ingress); err != nil {
+		t.Fatal(err)
+	}
+	listeners = buildIngressListeners(&mesh, nil, mock.Discovery, store, proxy.Node{Type: proxy.Ingress})
+	for _, filter := range listeners[0].Filters[0].Config.(*HTTPFilterConfig).Filters {
+		found = found || filter.Name == RateLimitFilter
+	}
+	if !found {
+		t.Error("buildIngressListeners() => missing rate limit filter")
+	}
+}
@@ -72,6 +72,9 @@ const (
 	// LbTypeOriginalDST is the name for LB of original_dst
 	LbTypeOriginalDST = "original_dst_lb"
 
+	// LbTypeRingHash is the name for ring hash LB
+	LbTypeRingHash = "ring_hash"
+
 	// ClusterFeatureHTTP2 is the feature to use HTTP/2 for a cluster
 	ClusterFeatureHTTP2 = "http2"
 
@@ -113,6 +116,28 @@ const (
 	// ZipkinCollectorEndpoint denotes the REST endpoint where Envoy posts Zipkin spans
 	ZipkinCollectorEndpoint = "/api/v1/spans"
 
+	// MirrorRuntimeKeyPrefix is the prefix of the runtime keys holding the
+	// percentages of the mirrored requests
+	MirrorRuntimeKeyPrefix = "mirror.percent_"
+
+	// RuntimeDirectory is the directory of the runtime under the config path
+	RuntimeDirectory = "runtime"
+
+	// RuntimeSubdirectory is the subdirectory of the runtime values loaded by Envoy
+	RuntimeSubdirectory = "envoy"
+
+	// LightStepTraceDriverType denotes the LightStep HTTP trace driver
+	LightStepTraceDriverType = "lightstep"
+
+	// LightStepCollectorCluster denotes the cluster where the LightStep collector is running
+	LightStepCollectorCluster = "lightstep"
+
+	// JaegerTraceDriverType denotes the dynamically loaded OpenTracing driver used for Jaeger
+	JaegerTraceDriverType = "dynamic_ot"
+
+	// JaegerTracerLibrary is the path to the Jaeger OpenTracing plugin in the proxy image
+	JaegerTracerLibrary = "/usr/local/lib/libjaegertracing_plugin.so"
+
 	router  = "router"
 	auto    = "auto"
 	decoder = "decoder"
@@ -143,8 +168,29 @@ type Config struct {
 	StatsdUDPIPAddress string         `json:"statsd_udp_ip_address,omitempty"`
 	Tracing            *Tracing       `json:"tracing,omitempty"`
 
+	RateLimitService *RateLimitService `json:"rate_limit_service,omitempty"`
+
 	// Special value used to hash all referenced values (e.g. TLS secrets)
 	Hash []byte `json:"-"`
+
+	// rendered is the output of the bootstrap template that is written in
+	// place of the generated config if set
+	rendered []byte
+
+	// runtime holds the values of the runtime keys that are written under
+	// the runtime root before the proxy starts
+	runtime map[string]string
+}
+
+// RateLimitService definition
+type RateLimitService struct {
+	Type   string                 `json:"type"`
+	Config RateLimitServiceConfig `json:"config"`
+}
+
+// RateLimitServiceConfig definition
+type RateLimitServiceConfig struct {
+	ClusterName string `json:"cluster_name"`
 }
 
 // Tracing definition
@@ -165,8 +211,15 @@ type HTTPTraceDriver struct {
 
 // HTTPTraceDriverConfig definition
 type HTTPTraceDriverConfig struct {
-	CollectorCluster  string `json:"collector_cluster"`
-	CollectorEndpoint string `json:"collector_endpoint"`
+	CollectorCluster  string `json:"collector_cluster,omitempty"`
+	CollectorEndpoint string `json:"collector_endpoint,omitempty"`
+
+	// LightStep driver settings
+	AccessTokenFile string `json:"access_token_file,omitempty"`
+
+	// OpenTracing driver settings
+	Library      string                 `json:"library,omitempty"`
+	TracerConfig map[string]interface{} `json:"config,omitempty"`
 }
 
 // RootRuntime definition.
@@ -197,6 +250,12 @@ type Header struct {
 	Regex bool   `json:"regex,omitempty"`
 }
 
+// HeaderValue definition
+type HeaderValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
 // FilterFaultConfig definition
 type FilterFaultConfig struct {
 	Abort           *AbortFilter `json:"abort,omitempty"`
@@ -229,6 +288,25 @@ type Decorator struct {
 	Operation string `json:"operation"`
 }
 
+// ShadowCluster definition
+// See: https://lyft.github.io/envoy/docs/configuration/http_conn_man/route_config/route.html#config-http-conn-man-route-table-route-shadow
+type ShadowCluster struct {
+	Cluster    string `json:"cluster"`
+	RuntimeKey string `json:"runtime_key,omitempty"`
+}
+
+// CorsPolicy definition
+// See: https://lyft.github.io/envoy/docs/configuration/http_filters/cors_filter.html
+type CorsPolicy struct {
+	Enabled          bool     `json:"enabled"`
+	AllowOrigin      []string `json:"allow_origin,omitempty"`
+	AllowMethods     string   `json:"allow_methods,omitempty"`
+	AllowHeaders     string   `json:"allow_headers,omitempty"`
+	ExposeHeaders    string   `json:"expose_headers,omitempty"`
+	MaxAge           string   `json:"max_age,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+}
+
 // HTTPRoute definition
 type HTTPRoute struct {
 	Runtime *Runtime `json:"runtime,omitempty"`
@@ -255,6 +333,16 @@ type HTTPRoute struct {
 
 	Decorator *Decorator `json:"decorator,omitempty"`
 
+	Shadow *ShadowCluster `json:"shadow,omitempty"`
+
+	RequestHeadersToAdd []HeaderValue `json:"request_headers_to_add,omitempty"`
+
+	Cors *CorsPolicy `json:"cors,omitempty"`
+
+	HashPolicy *HashPolicy `json:"hash_policy,omitempty"`
+
+	RateLimits []*RateLimit `json:"rate_limits,omitempty"`
+
 	// clusters contains the set of referenced clusters in the route; the field is special
 	// and used only to aggregate cluster information after composing routes
 	clusters Clusters
@@ -262,6 +350,13 @@ type HTTPRoute struct {
 	// faults contains the set of referenced faults in the route; the field is special
 	// and used only to aggregate fault filter information after composing routes
 	faults []*HTTPFilter
+
+	// mirror is the shadow cluster, which is also part of the referenced clusters
+	mirror *Cluster
+
+	// responseHeaders contains the response header operations of the route,
+	// which the v1 routes cannot carry; they are applied by the route config
+	responseHeaders []*model.HeaderOperation
 }
 
 // CatchAll returns true if the route matches all requests
@@ -299,6 +394,26 @@ func (route *HTTPRoute) CombinePathPrefix(path, prefix string) *HTTPRoute {
 	}
 }
 
+// HashPolicy definition for the ring hash load balancing of the route clusters
+// See: https://lyft.github.io/envoy/docs/configuration/http_conn_man/route_config/route.html#hash-policy
+type HashPolicy struct {
+	HeaderName string `json:"header_name"`
+}
+
+// RateLimit definition
+type RateLimit struct {
+	Stage   int               `json:"stage,omitempty"`
+	Actions []RateLimitAction `json:"actions"`
+}
+
+// RateLimitAction definition
+type RateLimitAction struct {
+	Type            string `json:"type"`
+	HeaderName      string `json:"header_name,omitempty"`
+	DescriptorKey   string `json:"descriptor_key,omitempty"`
+	DescriptorValue string `json:"descriptor_value,omitempty"`
+}
+
 // RetryPolicy definition
 // See: https://lyft.github.io/envoy/docs/configuration/http_conn_man/route_config/route.html#retry-policy
 type RetryPolicy struct {
@@ -338,6 +453,9 @@ func (host *VirtualHost) clusters() Clusters {
 // HTTPRouteConfig definition
 type HTTPRouteConfig struct {
 	VirtualHosts []*VirtualHost `json:"virtual_hosts"`
+
+	ResponseHeadersToAdd    []HeaderValue `json:"response_headers_to_add,omitempty"`
+	ResponseHeadersToRemove []string      `json:"response_headers_to_remove,omitempty"`
 }
 
 // HTTPRouteConfigs is a map from the port number to the route config
@@ -408,6 +526,63 @@ func (rc *HTTPRouteConfig) faults() []*HTTPFilter {
 	return out
 }
 
+// cors returns true if any route has a CORS policy
+func (rc *HTTPRouteConfig) cors() bool {
+	for _, host := range rc.VirtualHosts {
+		for _, route := range host.Routes {
+			if route.Cors != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rateLimits returns true if any route has rate limits
+func (rc *HTTPRouteConfig) rateLimits() bool {
+	for _, host := range rc.VirtualHosts {
+		for _, route := range host.Routes {
+			if len(route.RateLimits) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyResponseHeaders sets the response header operations of all the routes
+// on the route config in a deterministic order
+func (rc *HTTPRouteConfig) applyResponseHeaders() {
+	added := make(map[HeaderValue]bool)
+	removed := make(map[string]bool)
+	for _, host := range rc.VirtualHosts {
+		for _, route := range host.Routes {
+			for _, op := range route.responseHeaders {
+				for _, header := range buildHeaderValues(op.Add) {
+					added[header] = true
+				}
+				for _, name := range op.Remove {
+					removed[name] = true
+				}
+			}
+		}
+	}
+
+	rc.ResponseHeadersToAdd = nil
+	for header := range added {
+		rc.ResponseHeadersToAdd = append(rc.ResponseHeadersToAdd, header)
+	}
+	sort.Slice(rc.ResponseHeadersToAdd, func(i, j int) bool {
+		a, b := rc.ResponseHeadersToAdd[i], rc.ResponseHeadersToAdd[j]
+		return a.Key < b.Key || a.Key == b.Key && a.Value < b.Value
+	})
+	rc.ResponseHeadersToRemove = nil
+	for name := range removed {
+		rc.ResponseHeadersToRemove = append(rc.ResponseHeadersToRemove, name)
+	}
+	sort.Strings(rc.ResponseHeadersToRemove)
+}
+
 func (rc *HTTPRouteConfig) clusters() Clusters {
 	out := make(Clusters, 0)
 	for _, host := range rc.VirtualHosts {
@@ -511,6 +686,7 @@ func (r TCPRouteByRoute) Less(i, j int) bool {
 type TCPProxyFilterConfig struct {
 	StatPrefix  string          `json:"stat_prefix"`
 	RouteConfig *TCPRouteConfig `json:"route_config"`
+	AccessLog   []AccessLog     `json:"access_log,omitempty"`
 }
 
 func (*TCPProxyFilterConfig) isNetworkFilterConfig() {}
@@ -561,6 +737,10 @@ type Listener struct {
 	SSLContext     *SSLContext      `json:"ssl_context,omitempty"`
 	BindToPort     bool             `json:"bind_to_port"`
 	UseOriginalDst bool             `json:"use_original_dst,omitempty"`
+
+	// accessLogDisabled is set for the listeners of the services without
+	// access logs and is used only by the access log post-processing pass
+	accessLogDisabled bool
 }
 
 // Listeners is a collection of listeners
@@ -635,6 +815,7 @@ type Cluster struct {
 	Features                 string            `json:"features,omitempty"`
 	CircuitBreaker           *CircuitBreaker   `json:"circuit_breakers,omitempty"`
 	OutlierDetection         *OutlierDetection `json:"outlier_detection,omitempty"`
+	RingHashLbConfig         *RingHashLbConfig `json:"ring_hash_lb_config,omitempty"`
 
 	// special values used by the post-processing passes for outbound mesh-local clusters
 	outbound bool
@@ -643,6 +824,12 @@ type Cluster struct {
 	tags     model.Labels
 }
 
+// RingHashLbConfig definition
+// See: https://lyft.github.io/envoy/docs/configuration/cluster_manager/cluster.html#ring-hash-load-balancer-configuration
+type RingHashLbConfig struct {
+	MinimumRingSize int `json:"minimum_ring_size,omitempty"`
+}
+
 // CircuitBreaker definition
 // See: https://lyft.github.io/envoy/docs/configuration/cluster_manager/cluster_circuit_breakers.html#circuit-breakers
 type CircuitBreaker struct {
@@ -664,6 +851,15 @@ type OutlierDetection struct {
 	IntervalMS         int64 `json:"interval_ms,omitempty"`
 	BaseEjectionTimeMS int64 `json:"base_ejection_time_ms,omitempty"`
 	MaxEjectionPercent int   `json:"max_ejection_percent,omitempty"`
+
+	ConsecutiveGatewayFailure          int `json:"consecutive_gateway_failure,omitempty"`
+	EnforcingConsecutiveGatewayFailure int `json:"enforcing_consecutive_gateway_failure,omitempty"`
+
+	// EnforcingSuccessRate defaults to 100 in Envoy
+	EnforcingSuccessRate     *int `json:"enforcing_success_rate,omitempty"`
+	SuccessRateMinimumHosts  int  `json:"success_rate_minimum_hosts,omitempty"`
+	SuccessRateRequestVolume int  `json:"success_rate_request_volume,omitempty"`
+	SuccessRateStdevFactor   int  `json:"success_rate_stdev_factor,omitempty"`
 }
 
 // Clusters is a collection of clusters
@@ -24,6 +24,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/golang/glog"
 	"github.com/golang/protobuf/ptypes/duration"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
@@ -147,6 +148,11 @@ func buildHTTPRoute(config model.Config, service *model.Service, port *model.Por
 			// These are the safest retry policies as per envoy docs
 			Policy: "5xx,connect-failure,refused-stream",
 		}
+		if conditions, err := model.RouteRetryOn(config.ConfigMeta); err != nil {
+			glog.Warningf("Using default retry conditions for route rule %s: %v", config.Key(), err)
+		} else if len(conditions) > 0 {
+			route.RetryPolicy.Policy = strings.Join(conditions, ",")
+		}
 		if protoDurationToMS(rule.HttpReqRetries.GetSimpleRetry().PerTryTimeout) > 0 {
 			route.RetryPolicy.PerTryTimeoutMS = protoDurationToMS(rule.HttpReqRetries.GetSimpleRetry().PerTryTimeout)
 		}
@@ -206,6 +212,46 @@ func buildHTTPRoute(config model.Config, service *model.Service, port *model.Por
 		route.WebsocketUpgrade = true
 	}
 
+	// mirror the requests to the shadow cluster, which is referenced by the
+	// route for CDS but not subject to the fault filters; the port of the
+	// shadow cluster is resolved from the mirror service by applyMirrorPorts
+	if rule.Redirect == nil {
+		mirror, err := model.RouteMirror(config.ConfigMeta)
+		var percent *int32
+		if err == nil {
+			percent, err = model.RouteMirrorPercent(config.ConfigMeta)
+		}
+		if err != nil {
+			glog.Warningf("Skipping mirror for route rule %s: %v", config.Key(), err)
+		} else if mirror != nil && (percent == nil || *percent > 0) {
+			cluster := buildOutboundCluster(model.ResolveHostname(config.ConfigMeta, mirror), port, mirror.Labels)
+			route.Shadow = &ShadowCluster{Cluster: cluster.Name}
+			if percent != nil && *percent < 100 {
+				route.Shadow.RuntimeKey = mirrorRuntimeKey(*percent)
+			}
+			route.mirror = cluster
+			route.clusters = append(route.clusters, cluster)
+		}
+	}
+
+	if ops, err := model.RouteHeaderOperations(config.ConfigMeta); err != nil {
+		glog.Warningf("Skipping header operations for route rule %s: %v", config.Key(), err)
+	} else if ops != nil {
+		applyHeaderOperations(route, ops)
+	}
+
+	if cors, err := model.RouteCorsPolicy(config.ConfigMeta); err != nil {
+		glog.Warningf("Skipping CORS policy for route rule %s: %v", config.Key(), err)
+	} else if cors != nil {
+		route.Cors = buildCorsPolicy(cors)
+	}
+
+	if limits, err := model.RouteRateLimits(config.ConfigMeta); err != nil {
+		glog.Warningf("Skipping rate limits for route rule %s: %v", config.Key(), err)
+	} else if limits != nil {
+		route.RateLimits = buildRateLimits(limits)
+	}
+
 	route.Decorator = buildDecorator(config)
 
 	return route
@@ -234,20 +280,6 @@ func buildDecorator(config model.Config) *Decorator {
 	return nil
 }
 
-func buildZipkinTracing() *Tracing {
-	return &Tracing{
-		HTTPTracer: HTTPTracer{
-			HTTPTraceDriver: HTTPTraceDriver{
-				HTTPTraceDriverType: ZipkinTraceDriverType,
-				HTTPTraceDriverConfig: HTTPTraceDriverConfig{
-					CollectorCluster:  ZipkinCollectorCluster,
-					CollectorEndpoint: ZipkinCollectorEndpoint,
-				},
-			},
-		},
-	}
-}
-
 // buildVirtualHost constructs an entry for VirtualHost for a destination service.
 // The unique name for a virtual host is a combination of the destination service and the port, e.g.
 // "svc.ns.svc.cluster.local:http".
@@ -347,6 +379,104 @@ func buildTCPRoute(cluster *Cluster, addresses []string) *TCPRoute {
 	return route
 }
 
+// buildTCPRoutes translates the route rules for a TCP service port to Envoy
+// TCP routes in the order of precedence, followed by the default route unless
+// a rule matches all connections. Only the L4 match conditions and the
+// destination weights of the rules apply, and the rules with request header
+// conditions are skipped. The addresses restrict the routes without a
+// destination subnet condition.
+func buildTCPRoutes(rules []model.Config, service *model.Service, port *model.Port, addresses []string) []*TCPRoute {
+	routes := make([]*TCPRoute, 0, len(rules)+1)
+	for _, config := range rules {
+		rule := config.Spec.(*proxyconfig.RouteRule)
+		if rule.Match != nil && rule.Match.Request != nil && len(rule.Match.Request.Headers) > 0 {
+			glog.V(4).Infof("Skipping route rule %s with request conditions for TCP port %s of %s",
+				config.Key(), port.Name, service.Hostname)
+			continue
+		}
+
+		cluster := buildTCPRuleCluster(config, service, port)
+		if cluster == nil {
+			continue
+		}
+
+		route := buildTCPRoute(cluster, addresses)
+		catchAll := true
+		if rule.Match != nil && rule.Match.Tcp != nil {
+			if len(rule.Match.Tcp.SourceSubnet) > 0 {
+				route.SourceIPList = buildSubnets(rule.Match.Tcp.SourceSubnet)
+				catchAll = false
+			}
+			if len(rule.Match.Tcp.DestinationSubnet) > 0 {
+				route.DestinationIPList = buildSubnets(rule.Match.Tcp.DestinationSubnet)
+				catchAll = false
+			}
+		}
+		routes = append(routes, route)
+
+		// the routes after a catch-all route are unreachable
+		if catchAll {
+			return routes
+		}
+	}
+
+	return append(routes, buildTCPRoute(buildOutboundCluster(service.Hostname, port, nil), addresses))
+}
+
+// buildTCPRuleCluster returns the cluster for the destination weights of a
+// route rule. TCP routes cannot split the connections between clusters,
+// therefore multiple weighted destinations are combined into a single cluster
+// with weighted hosts. The weighted destinations must be label subsets of the
+// rule destination.
+func buildTCPRuleCluster(config model.Config, service *model.Service, port *model.Port) *Cluster {
+	rule := config.Spec.(*proxyconfig.RouteRule)
+	switch len(rule.Route) {
+	case 0:
+		return buildOutboundCluster(service.Hostname, port, nil)
+	case 1:
+		hostname := service.Hostname
+		if rule.Route[0].Destination != nil {
+			hostname = model.ResolveHostname(config.ConfigMeta, rule.Route[0].Destination)
+		}
+		return buildOutboundCluster(hostname, port, rule.Route[0].Labels)
+	}
+
+	weights := make([]model.WeightedLabels, 0, len(rule.Route))
+	for _, dst := range rule.Route {
+		if (dst.Destination != nil && model.ResolveHostname(config.ConfigMeta, dst.Destination) != service.Hostname) ||
+			len(dst.Labels) == 0 {
+			glog.Warningf("Skipping route rule %s: weighted TCP routes must split %s by labels",
+				config.Key(), service.Hostname)
+			return nil
+		}
+		weights = append(weights, model.WeightedLabels{Labels: dst.Labels, Weight: int(dst.Weight)})
+	}
+	return buildWeightedOutboundCluster(service.Hostname, port, weights)
+}
+
+// buildWeightedOutboundCluster builds a cluster for the union of the label
+// subsets of the service. The service discovery assigns the host weights from
+// the weights encoded in the service key.
+func buildWeightedOutboundCluster(hostname string, port *model.Port, weights []model.WeightedLabels) *Cluster {
+	cluster := buildOutboundCluster(hostname, port, nil)
+	key := model.WeightedServiceKey(hostname, port, weights)
+	cluster.Name = OutboundClusterPrefix + fmt.Sprintf("%x", sha1.Sum([]byte(key)))
+	cluster.ServiceName = key
+	return cluster
+}
+
+// buildSubnets converts IPv4 addresses and subnets to CIDR notation
+func buildSubnets(subnets []string) []string {
+	out := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		if !strings.Contains(subnet, "/") {
+			subnet = subnet + "/32"
+		}
+		out = append(out, subnet)
+	}
+	return out
+}
+
 func buildOriginalDSTCluster(name string, timeout *duration.Duration) *Cluster {
 	return &Cluster{
 		Name:             OutboundClusterPrefix + name,
@@ -356,3 +486,76 @@ func buildOriginalDSTCluster(name string, timeout *duration.Duration) *Cluster {
 		outbound:         true,
 	}
 }
+
+// mirrorRuntimeKey returns the runtime key holding the percentage of the
+// mirrored requests. Envoy v1 reads the shadow percentages from the runtime
+// only, so the proxy agents write a key for each mirror percentage of the
+// mesh config.
+func mirrorRuntimeKey(percent int32) string {
+	return fmt.Sprintf("%s%d", MirrorRuntimeKeyPrefix, percent)
+}
+
+// buildMirrorRuntime returns the runtime values of the mirror percentages in
+// hundredths of a percent
+func buildMirrorRuntime(percentages []int32) map[string]string {
+	out := make(map[string]string, len(percentages))
+	for _, percent := range percentages {
+		out[mirrorRuntimeKey(percent)] = fmt.Sprint(percent * 100)
+	}
+	return out
+}
+
+// applyMirrorPorts resolves the ports of the shadow clusters of the routes
+// from the mirror services, which may name and number their ports
+// differently from the destination services. The mirror port with the name
+// of the destination port is preferred, then the port with its number. The
+// requests are not mirrored if the mirror service has no such HTTP port.
+func applyMirrorPorts(configs HTTPRouteConfigs, services []*model.Service) {
+	byHostname := make(map[string]*model.Service, len(services))
+	for _, service := range services {
+		byHostname[service.Hostname] = service
+	}
+
+	for _, config := range configs {
+		for _, host := range config.VirtualHosts {
+			for _, route := range host.Routes {
+				if route.mirror == nil || route.Shadow == nil {
+					continue
+				}
+				var port *model.Port
+				if service, exists := byHostname[route.mirror.hostname]; exists {
+					port = mirrorPort(service, route.mirror.port)
+				}
+				if port == nil {
+					glog.Warningf("Skipping mirror to %s without a port matching %s",
+						route.mirror.hostname, route.mirror.port.Name)
+					clusters := make(Clusters, 0, len(route.clusters))
+					for _, cluster := range route.clusters {
+						if cluster != route.mirror {
+							clusters = append(clusters, cluster)
+						}
+					}
+					route.clusters = clusters
+					route.Shadow = nil
+					route.mirror = nil
+					continue
+				}
+				*route.mirror = *buildOutboundCluster(route.mirror.hostname, port, route.mirror.tags)
+				route.Shadow.Cluster = route.mirror.Name
+			}
+		}
+	}
+}
+
+// mirrorPort returns the HTTP port of the mirror service with the name, or
+// else the number, of the destination port
+func mirrorPort(service *model.Service, port *model.Port) *model.Port {
+	mirror, exists := service.Ports.Get(port.Name)
+	if !exists {
+		mirror, exists = service.Ports.GetByPort(port.Port)
+	}
+	if !exists || !mirror.Protocol.IsHTTP() {
+		return nil
+	}
+	return mirror
+}
@@ -15,8 +15,13 @@
 package envoy
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
 )
 
 var (
@@ -58,3 +63,173 @@ func TestBuildListenerSSLContext(t *testing.T) {
 			dir, context.RequireClientCertificate)
 	}
 }
+
+func TestBuildHTTPRouteMirror(t *testing.T) {
+	config := model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:        model.RouteRule.Type,
+			Name:        "mirror",
+			Namespace:   "default",
+			Domain:      "cluster.local",
+			Annotations: map[string]string{model.MirrorAnnotation: `{"name":"hello","labels":{"version":"v1"}}`},
+		},
+		Spec: &proxyconfig.RouteRule{
+			Destination: &proxyconfig.IstioService{Name: "world"},
+		},
+	}
+
+	route := buildHTTPRoute(config, mock.WorldService, mock.PortHTTP)
+	if route.Shadow == nil {
+		t.Fatalf("buildHTTPRoute() => Got no shadow cluster")
+	}
+	if len(route.clusters) != 2 {
+		t.Fatalf("buildHTTPRoute() => Got clusters %v, expected the route and the shadow cluster", route.clusters)
+	}
+	shadow := route.clusters[1]
+	if shadow.Name != route.Shadow.Cluster || shadow.hostname != mock.HelloService.Hostname ||
+		!shadow.tags.Equals(model.Labels{"version": "v1"}) {
+		t.Errorf("buildHTTPRoute() => Got shadow cluster %#v, expected hello version v1", shadow)
+	}
+	if route.Cluster == route.Shadow.Cluster {
+		t.Errorf("buildHTTPRoute() => Got requests routed to the shadow cluster")
+	}
+	if route.Shadow.RuntimeKey != "" {
+		t.Errorf("buildHTTPRoute() => Got runtime key %q, expected all the requests mirrored", route.Shadow.RuntimeKey)
+	}
+
+	// the percentage of the mirrored requests is read from the runtime
+	config.Annotations[model.MirrorAnnotation] = `{"name":"hello","percent":25}`
+	route = buildHTTPRoute(config, mock.WorldService, mock.PortHTTP)
+	if route.Shadow == nil || route.Shadow.RuntimeKey != mirrorRuntimeKey(25) {
+		t.Errorf("buildHTTPRoute() => Got shadow %#v, expected runtime key %s", route.Shadow, mirrorRuntimeKey(25))
+	}
+	if got := buildMirrorRuntime([]int32{25})[mirrorRuntimeKey(25)]; got != "2500" {
+		t.Errorf("buildMirrorRuntime() => Got %q for 25 percent, expected 2500", got)
+	}
+	config.Annotations[model.MirrorAnnotation] = `{"name":"hello","percent":0}`
+	if route = buildHTTPRoute(config, mock.WorldService, mock.PortHTTP); route.Shadow != nil || len(route.clusters) != 1 {
+		t.Errorf("buildHTTPRoute() => Got shadow %#v, expected no mirrored requests", route.Shadow)
+	}
+
+	// redirected requests are not mirrored
+	config.Spec.(*proxyconfig.RouteRule).Redirect = &proxyconfig.HTTPRedirect{Uri: "/new"}
+	if route = buildHTTPRoute(config, mock.WorldService, mock.PortHTTP); route.Shadow != nil {
+		t.Errorf("buildHTTPRoute() => Got shadow cluster %v for a redirect", route.Shadow)
+	}
+}
+
+func TestApplyMirrorPorts(t *testing.T) {
+	config := model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:        model.RouteRule.Type,
+			Name:        "mirror",
+			Namespace:   "default",
+			Domain:      "cluster.local",
+			Annotations: map[string]string{model.MirrorAnnotation: `{"name":"hello"}`},
+		},
+		Spec: &proxyconfig.RouteRule{
+			Destination: &proxyconfig.IstioService{Name: "world"},
+		},
+	}
+	port := &model.Port{Name: "http-world", Port: 81, Protocol: model.ProtocolHTTP}
+	mirrorConfigs := func(services []*model.Service) *HTTPRoute {
+		route := buildHTTPRoute(config, mock.WorldService, port)
+		applyMirrorPorts(HTTPRouteConfigs{81: {VirtualHosts: []*VirtualHost{{Routes: []*HTTPRoute{route}}}}},
+			services)
+		return route
+	}
+
+	// the mirror port is resolved by the number without a port of the same name
+	route := mirrorConfigs([]*model.Service{mock.HelloService})
+	if route.Shadow == nil || route.mirror.port.Name != "http-status" ||
+		route.Shadow.Cluster != route.mirror.Name || route.clusters[1] != route.mirror {
+		t.Errorf("applyMirrorPorts() => Got shadow %#v and cluster %#v, expected port http-status",
+			route.Shadow, route.mirror)
+	}
+
+	port = mock.PortHTTP
+	if route = mirrorConfigs([]*model.Service{mock.HelloService}); route.Shadow == nil ||
+		route.mirror.port != mock.PortHTTP {
+		t.Errorf("applyMirrorPorts() => Got shadow %#v, expected port http", route.Shadow)
+	}
+
+	// unknown mirror services are not mirrored
+	if route = mirrorConfigs(nil); route.Shadow != nil || len(route.clusters) != 1 {
+		t.Errorf("applyMirrorPorts() => Got shadow %#v and clusters %v for an unknown service",
+			route.Shadow, route.clusters)
+	}
+}
+
+func TestBuildTCPRoutes(t *testing.T) {
+	port := &model.Port{Name: "tcp", Port: 90, Protocol: model.ProtocolTCP}
+	meta := model.ConfigMeta{Type: model.RouteRule.Type, Namespace: "default", Domain: "cluster.local"}
+	subnetRule := model.Config{ConfigMeta: meta, Spec: &proxyconfig.RouteRule{
+		Destination: &proxyconfig.IstioService{Name: "world"},
+		Precedence:  2,
+		Match: &proxyconfig.MatchCondition{
+			Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"10.1.0.0/16", "10.3.3.3"}},
+		},
+		Route: []*proxyconfig.DestinationWeight{{Labels: map[string]string{"version": "v1"}}},
+	}}
+	subnetRule.Name = "subnet"
+	weightedRule := model.Config{ConfigMeta: meta, Spec: &proxyconfig.RouteRule{
+		Destination: &proxyconfig.IstioService{Name: "world"},
+		Precedence:  1,
+		Route: []*proxyconfig.DestinationWeight{
+			{Labels: map[string]string{"version": "v0"}, Weight: 75},
+			{Labels: map[string]string{"version": "v1"}, Weight: 25},
+		},
+	}}
+	weightedRule.Name = "weighted"
+
+	routes := buildTCPRoutes(nil, mock.WorldService, port, []string{mock.WorldService.Address})
+	if len(routes) != 1 || routes[0].clusterRef.tags != nil ||
+		len(routes[0].DestinationIPList) != 1 || routes[0].DestinationIPList[0] != "10.2.0.0/32" {
+		t.Errorf("buildTCPRoutes() => got %#v, want the default route", routes)
+	}
+
+	routes = buildTCPRoutes([]model.Config{subnetRule, weightedRule}, mock.WorldService, port, nil)
+	if len(routes) != 2 {
+		t.Fatalf("buildTCPRoutes() => got %d routes, want the subnet and the weighted routes", len(routes))
+	}
+	if want := []string{"10.1.0.0/16", "10.3.3.3/32"}; !reflect.DeepEqual(routes[0].SourceIPList, want) ||
+		!routes[0].clusterRef.tags.Equals(model.Labels{"version": "v1"}) {
+		t.Errorf("buildTCPRoutes() => got %#v, want source subnets %v to version v1", routes[0], want)
+	}
+	weights := model.ParseServiceKeyWeights(routes[1].clusterRef.ServiceName)
+	if len(weights) != 2 || weights[0].Weight != 75 || weights[1].Weight != 25 || routes[1].SourceIPList != nil {
+		t.Errorf("buildTCPRoutes() => got %#v, want the weighted catch-all route", routes[1].clusterRef)
+	}
+
+	// weighted routes to other services are skipped
+	weightedRule.Spec.(*proxyconfig.RouteRule).Route[1].Destination = &proxyconfig.IstioService{Name: "hello"}
+	routes = buildTCPRoutes([]model.Config{weightedRule}, mock.WorldService, port, nil)
+	if len(routes) != 1 || routes[0].clusterRef.ServiceName != mock.WorldService.Key(port, nil) {
+		t.Errorf("buildTCPRoutes() => got %#v, want the default route", routes)
+	}
+}
+
+func TestBuildHTTPRouteRetryOn(t *testing.T) {
+	config := model.Config{
+		ConfigMeta: model.ConfigMeta{Type: model.RouteRule.Type, Name: "retry", Namespace: "default"},
+		Spec: &proxyconfig.RouteRule{
+			Destination: &proxyconfig.IstioService{Name: "world"},
+			HttpReqRetries: &proxyconfig.HTTPRetry{
+				RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{
+					SimpleRetry: &proxyconfig.HTTPRetry_SimpleRetryPolicy{Attempts: 2},
+				},
+			},
+		},
+	}
+
+	route := buildHTTPRoute(config, mock.WorldService, mock.PortHTTP)
+	if route.RetryPolicy == nil || route.RetryPolicy.Policy != "5xx,connect-failure,refused-stream" {
+		t.Errorf("buildHTTPRoute() => got retry policy %#v, want the default conditions", route.RetryPolicy)
+	}
+
+	config.Annotations = map[string]string{model.RetryOnAnnotation: `["gateway-error","reset"]`}
+	route = buildHTTPRoute(config, mock.WorldService, mock.PortHTTP)
+	if route.RetryPolicy == nil || route.RetryPolicy.NumRetries != 2 || route.RetryPolicy.Policy != "gateway-error,reset" {
+		t.Errorf("buildHTTPRoute() => got retry policy %#v, want gateway-error and reset", route.RetryPolicy)
+	}
+}
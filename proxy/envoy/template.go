@@ -0,0 +1,107 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Functions related to the rendering of the proxy bootstrap from a Go
+// template. The template extends or replaces the generated bootstrap.
+
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"text/template"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+// BootstrapTemplateData is the input of the bootstrap template
+type BootstrapTemplateData struct {
+	// Config is the proxy config
+	Config proxyconfig.ProxyConfig
+
+	// Node is the proxy node
+	Node proxy.Node
+
+	// ServiceNode is the service node name of the proxy
+	ServiceNode string
+
+	// PilotSAN is the subject alternate names of the discovery service
+	PilotSAN []string
+
+	// CertChainFile, KeyFile, and RootCertFile are the paths of the mutual
+	// TLS certificates
+	CertChainFile string
+	KeyFile       string
+	RootCertFile  string
+
+	// Generated is the bootstrap generated by the agent
+	Generated *Config
+}
+
+// bootstrapTemplateFuncs are the functions available to the bootstrap template
+var bootstrapTemplateFuncs = template.FuncMap{
+	// toJSON renders a value, e.g. a part of the generated bootstrap, as JSON
+	"toJSON": func(value interface{}) (string, error) {
+		out, err := json.Marshal(value)
+		return string(out), err
+	},
+}
+
+// parseBootstrapTemplate reads and parses the bootstrap template file
+func parseBootstrapTemplate(file string) (*template.Template, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(path.Base(file)).Funcs(bootstrapTemplateFuncs).Option("missingkey=error").Parse(string(content))
+}
+
+// renderBootstrap renders the bootstrap template file and checks that the
+// output is JSON
+func renderBootstrap(file string, data BootstrapTemplateData) ([]byte, error) {
+	tmpl, err := parseBootstrapTemplate(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal(out.Bytes(), &parsed); err != nil {
+		return nil, fmt.Errorf("rendered bootstrap is not JSON: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// buildBootstrapTemplateData collects the inputs of the bootstrap template
+func buildBootstrapTemplateData(config proxyconfig.ProxyConfig, node proxy.Node, pilotSAN []string,
+	generated *Config) BootstrapTemplateData {
+	return BootstrapTemplateData{
+		Config:        config,
+		Node:          node,
+		ServiceNode:   node.ServiceNode(),
+		PilotSAN:      pilotSAN,
+		CertChainFile: path.Join(proxy.AuthCertsPath, proxy.CertChainFilename),
+		KeyFile:       path.Join(proxy.AuthCertsPath, proxy.KeyFilename),
+		RootCertFile:  path.Join(proxy.AuthCertsPath, proxy.RootCertFilename),
+		Generated:     generated,
+	}
+}
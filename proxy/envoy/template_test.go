@@ -0,0 +1,144 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"istio.io/pilot/proxy"
+)
+
+// extraClusterTemplate adds a static cluster to the generated bootstrap
+const extraClusterTemplate = `{
+  "listeners": [],
+  "lds": {{ toJSON .Generated.LDS }},
+  "admin": {{ toJSON .Generated.Admin }},
+  "cluster_manager": {
+    "clusters": [
+      {{ range .Generated.ClusterManager.Clusters }}{{ toJSON . }},{{ end }}
+      {"name": "extra", "type": "static", "connect_timeout_ms": 1000, "lb_type": "round_robin",
+       "hosts": [{"url": "tcp://127.0.0.1:9999"}]}
+    ],
+    "cds": {{ toJSON .Generated.ClusterManager.CDS }}
+  },
+  "node": "{{ .ServiceNode }}",
+  "cert": "{{ .CertChainFile }}"
+}`
+
+func writeTemplate(t *testing.T, dir, content string) string {
+	file := path.Join(dir, "bootstrap.tmpl")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write file %s (error %v)", file, err)
+	}
+	return file
+}
+
+func TestRenderBootstrap(t *testing.T) {
+	dir, err := ioutil.TempDir("testdata", "template")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	config := proxy.DefaultProxyConfig()
+	node := proxy.Node{Type: proxy.Sidecar, IPAddress: "10.1.1.1", ID: "pod.default", Domain: "default.svc.cluster.local"}
+	data := buildBootstrapTemplateData(config, node, nil,
+		buildConfig(config, DefaultBootstrapConfig(), proxy.DefaultMeshExtensions(), nil))
+
+	out, err := renderBootstrap(writeTemplate(t, dir, extraClusterTemplate), data)
+	if err != nil {
+		t.Fatalf("renderBootstrap() => unexpected error %v", err)
+	}
+	var rendered struct {
+		ClusterManager struct {
+			Clusters []struct {
+				Name string `json:"name"`
+			} `json:"clusters"`
+		} `json:"cluster_manager"`
+		Node string `json:"node"`
+		Cert string `json:"cert"`
+	}
+	if err := json.Unmarshal(out, &rendered); err != nil {
+		t.Fatalf("renderBootstrap() => invalid JSON %v", err)
+	}
+	clusters := rendered.ClusterManager.Clusters
+	if len(clusters) != len(data.Generated.ClusterManager.Clusters)+1 || clusters[len(clusters)-1].Name != "extra" {
+		t.Errorf("renderBootstrap() => got clusters %v, want the generated clusters and the extra cluster", clusters)
+	}
+	if rendered.Node != node.ServiceNode() || rendered.Cert != data.CertChainFile {
+		t.Errorf("renderBootstrap() => got node %q and cert %q", rendered.Node, rendered.Cert)
+	}
+
+	for _, invalid := range []string{`{"node": "{{ .ServiceNode }"}`, `{"node": {{ .ServiceNode }}}`, `{{ .Missing }}`} {
+		if _, err := renderBootstrap(writeTemplate(t, dir, invalid), data); err == nil {
+			t.Errorf("renderBootstrap(%q) => expected an error", invalid)
+		}
+	}
+}
+
+func TestRenderBootstrapTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("testdata", "template")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	bootstrap := DefaultBootstrapConfig()
+	bootstrap.TemplateFile = writeTemplate(t, dir, `{"node": "{{ .ServiceNode }}"}`)
+	if err := ValidateBootstrapConfig(bootstrap); err != nil {
+		t.Errorf("ValidateBootstrapConfig() => unexpected error %v", err)
+	}
+	node := proxy.Node{Type: proxy.Sidecar, IPAddress: "10.1.1.1", ID: "pod.default", Domain: "default.svc.cluster.local"}
+	backend := NewBackend(proxy.BackendOptions{Config: proxy.DefaultProxyConfig(), Node: node}, bootstrap)
+	if len(backend.Directories) != 1 || backend.Directories[0] != dir {
+		t.Errorf("NewBackend() => got monitored directories %v, want the template directory", backend.Directories)
+	}
+
+	config, err := backend.Renderer.Render(proxy.DefaultProxyConfig(), []byte("hash"))
+	if err != nil {
+		t.Fatalf("Render() => unexpected error %v", err)
+	}
+	var out bytes.Buffer
+	if err := config.(*Config).Write(&out); err != nil || out.String() != `{"node": "`+node.ServiceNode()+`"}` {
+		t.Errorf("Write() => got %q (error %v), want the rendered template", out.String(), err)
+	}
+
+	// invalid templates fail rendering so that the watcher keeps the
+	// previous configuration
+	writeTemplate(t, dir, `{"node": {{ .ServiceNode }}}`)
+	if _, err := backend.Renderer.Render(proxy.DefaultProxyConfig(), []byte("hash")); err == nil {
+		t.Error("Render() => expected an error for a template rendering invalid JSON")
+	}
+	if err := ValidateBootstrapConfig(bootstrap); err != nil {
+		t.Errorf("ValidateBootstrapConfig() => unexpected error %v for a template rendering invalid JSON", err)
+	}
+	bootstrap.TemplateFile = path.Join(dir, "missing.tmpl")
+	if err := ValidateBootstrapConfig(bootstrap); err == nil {
+		t.Error("ValidateBootstrapConfig() => expected an error for a missing template")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+// TraceSamplingRuntimeKey is the runtime key holding the traced requests in
+// hundredths of a percent, from 0 to 10000
+const TraceSamplingRuntimeKey = "tracing.random_sampling"
+
+// buildTracing creates the tracer of the proxy and the collector cluster if
+// the tracer posts the spans over HTTP, or nil if the tracer has no address
+func buildTracing(config proxyconfig.ProxyConfig, tracing proxy.TracingConfig) (*Tracing, *Cluster) {
+	switch tracing.Driver {
+	case proxy.JaegerTracer:
+		return buildJaegerTracing(config.ServiceCluster, tracing.Address), nil
+	case proxy.LightStepTracer:
+		// LightStep collectors accept gRPC reports
+		cluster := buildCluster(tracing.Address, LightStepCollectorCluster, config.ConnectTimeout)
+		cluster.Features = ClusterFeatureHTTP2
+		return buildLightStepTracing(tracing.AccessTokenFile), cluster
+	default:
+		if config.ZipkinAddress == "" {
+			return nil, nil
+		}
+		return buildZipkinTracing(), buildCluster(config.ZipkinAddress, ZipkinCollectorCluster, config.ConnectTimeout)
+	}
+}
+
+func buildZipkinTracing() *Tracing {
+	return &Tracing{
+		HTTPTracer: HTTPTracer{
+			HTTPTraceDriver: HTTPTraceDriver{
+				HTTPTraceDriverType: ZipkinTraceDriverType,
+				HTTPTraceDriverConfig: HTTPTraceDriverConfig{
+					CollectorCluster:  ZipkinCollectorCluster,
+					CollectorEndpoint: ZipkinCollectorEndpoint,
+				},
+			},
+		},
+	}
+}
+
+func buildLightStepTracing(accessTokenFile string) *Tracing {
+	return &Tracing{
+		HTTPTracer: HTTPTracer{
+			HTTPTraceDriver: HTTPTraceDriver{
+				HTTPTraceDriverType: LightStepTraceDriverType,
+				HTTPTraceDriverConfig: HTTPTraceDriverConfig{
+					CollectorCluster: LightStepCollectorCluster,
+					AccessTokenFile:  accessTokenFile,
+				},
+			},
+		},
+	}
+}
+
+// buildJaegerTracing loads the Jaeger tracer plugin. The proxy decides which
+// requests are traced so the Jaeger sampler keeps all the spans.
+func buildJaegerTracing(serviceName, agentAddress string) *Tracing {
+	return &Tracing{
+		HTTPTracer: HTTPTracer{
+			HTTPTraceDriver: HTTPTraceDriver{
+				HTTPTraceDriverType: JaegerTraceDriverType,
+				HTTPTraceDriverConfig: HTTPTraceDriverConfig{
+					Library: JaegerTracerLibrary,
+					TracerConfig: map[string]interface{}{
+						"service_name": serviceName,
+						"sampler": map[string]interface{}{
+							"type":  "const",
+							"param": 1,
+						},
+						"reporter": map[string]interface{}{
+							"localAgentHostPort": agentAddress,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// buildTraceSamplingRuntime returns the runtime value of the trace sampling
+// percentage. The HTTP connection managers of Envoy v1 read the sampling
+// from the runtime only.
+func buildTraceSamplingRuntime(sampling float64) string {
+	return fmt.Sprint(int(sampling*100 + 0.5))
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestBuildConfigTracing(t *testing.T) {
+	proxyConfig := proxy.DefaultProxyConfig()
+	proxyConfig.ZipkinAddress = "zipkin:9411"
+
+	cases := []struct {
+		tracing proxy.TracingConfig
+		driver  string
+		cluster string
+	}{
+		{tracing: proxy.DefaultTracingConfig(), driver: ZipkinTraceDriverType, cluster: ZipkinCollectorCluster},
+		{tracing: proxy.TracingConfig{Driver: proxy.JaegerTracer, Address: "jaeger-agent:6831"},
+			driver: JaegerTraceDriverType},
+		{tracing: proxy.TracingConfig{Driver: proxy.LightStepTracer, Address: "lightstep:8080",
+			AccessTokenFile: "/etc/lightstep/token"}, driver: LightStepTraceDriverType, cluster: LightStepCollectorCluster},
+	}
+	for _, c := range cases {
+		config := buildConfig(proxyConfig, DefaultBootstrapConfig(), proxy.MeshExtensions{Tracing: c.tracing}, nil)
+		if config.Tracing == nil || config.Tracing.HTTPTracer.HTTPTraceDriver.HTTPTraceDriverType != c.driver {
+			t.Errorf("buildConfig(%v) => got tracing %#v, want driver %s", c.tracing.Driver, config.Tracing, c.driver)
+		}
+
+		collector := ""
+		for _, cluster := range config.ClusterManager.Clusters {
+			if cluster.Name == ZipkinCollectorCluster || cluster.Name == LightStepCollectorCluster {
+				collector = cluster.Name
+			}
+		}
+		if collector != c.cluster {
+			t.Errorf("buildConfig(%v) => got collector cluster %q, want %q", c.tracing.Driver, collector, c.cluster)
+		}
+	}
+
+	jaeger := proxy.TracingConfig{Driver: proxy.JaegerTracer, Address: "jaeger-agent:6831"}
+	config := buildConfig(proxyConfig, DefaultBootstrapConfig(), proxy.MeshExtensions{Tracing: jaeger}, nil)
+	reporter := config.Tracing.HTTPTracer.HTTPTraceDriver.HTTPTraceDriverConfig.TracerConfig["reporter"]
+	if got := reporter.(map[string]interface{})["localAgentHostPort"]; got != jaeger.Address {
+		t.Errorf("buildConfig(%v) => got agent %v, want %s", jaeger.Driver, got, jaeger.Address)
+	}
+
+	proxyConfig.ZipkinAddress = ""
+	config = buildConfig(proxyConfig, DefaultBootstrapConfig(), proxy.DefaultMeshExtensions(), nil)
+	if config.Tracing != nil {
+		t.Errorf("buildConfig() => got tracing %#v without a Zipkin address", config.Tracing)
+	}
+}
+
+func TestBuildConfigTraceSampling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	proxyConfig := proxy.DefaultProxyConfig()
+	proxyConfig.ZipkinAddress = "zipkin:9411"
+	proxyConfig.ConfigPath = dir
+
+	config := buildConfig(proxyConfig, DefaultBootstrapConfig(), proxy.DefaultMeshExtensions(), nil)
+	if config.RootRuntime != nil {
+		t.Errorf("buildConfig() => got runtime %#v without a sampling", config.RootRuntime)
+	}
+
+	cases := []struct {
+		sampling float64
+		want     string
+	}{
+		{sampling: 0, want: "0"},
+		{sampling: 1.5, want: "150"},
+		{sampling: 100, want: "10000"},
+	}
+	for _, c := range cases {
+		extensions := proxy.DefaultMeshExtensions()
+		extensions.Tracing.Sampling = &c.sampling
+		config = buildConfig(proxyConfig, DefaultBootstrapConfig(), extensions, nil)
+		if config.RootRuntime == nil {
+			t.Fatalf("buildConfig(%v) => got no runtime", c.sampling)
+		}
+		if err := config.writeRuntime(dir); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(path.Join(config.RootRuntime.SymlinkRoot, config.RootRuntime.Subdirectory,
+			"tracing", "random_sampling"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != c.want {
+			t.Errorf("buildConfig(%v) => got runtime sampling %q, want %q", c.sampling, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inprocess provides a proxy backend running within the agent
+// process. It applies the configurations without starting a data plane, so
+// that the agent runs in the tests and the continuous integration without a
+// proxy binary.
+package inprocess
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+// BackendName is the name of the in-process proxy backend
+const BackendName = "inprocess"
+
+// Config is the configuration of the in-process proxy
+type Config struct {
+	// ProxyConfig is the effective proxy config
+	ProxyConfig proxyconfig.ProxyConfig
+
+	// ServiceNode is the service node name of the proxy
+	ServiceNode string
+
+	// CertHash is the hash of the monitored certificates
+	CertHash []byte
+}
+
+// Proxy records the configurations of the running epochs
+type Proxy struct {
+	mutex    sync.Mutex
+	epochs   map[int]*Config
+	draining bool
+}
+
+// NewProxy creates an in-process proxy without running epochs
+func NewProxy() *Proxy {
+	return &Proxy{epochs: make(map[int]*Config)}
+}
+
+// NewBackend creates the in-process proxy backend
+func NewBackend(options proxy.BackendOptions) (proxy.Backend, error) {
+	return proxy.Backend{
+		Proxy:    NewProxy(),
+		Renderer: renderer{serviceNode: options.Node.ServiceNode()},
+	}, nil
+}
+
+// Current returns the configuration of the latest running epoch and the
+// epoch, or nil if no epoch runs
+func (p *Proxy) Current() (*Config, int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	latest := -1
+	for epoch := range p.epochs {
+		if epoch > latest {
+			latest = epoch
+		}
+	}
+	if latest < 0 {
+		return nil, latest
+	}
+	return p.epochs[latest], latest
+}
+
+// Run records the configuration of the epoch until the epoch is aborted
+func (p *Proxy) Run(config interface{}, epoch int, abort <-chan error) error {
+	inprocessConfig, ok := config.(*Config)
+	if !ok {
+		return fmt.Errorf("unexpected config type: %#v", config)
+	}
+
+	p.mutex.Lock()
+	p.epochs[epoch] = inprocessConfig
+	p.mutex.Unlock()
+	glog.V(2).Infof("Started in-process proxy epoch %d", epoch)
+
+	err := <-abort
+	glog.Warningf("Aborting epoch %d", epoch)
+	return err
+}
+
+// Cleanup forgets the configuration of the epoch
+func (p *Proxy) Cleanup(epoch int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.epochs, epoch)
+}
+
+// Panic terminates the agent
+func (p *Proxy) Panic(_ interface{}) {
+	glog.Fatal("cannot start the proxy with the desired configuration")
+}
+
+// Drain marks the proxy as not ready
+func (p *Proxy) Drain() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.draining = true
+	return nil
+}
+
+// Ready returns nil if an epoch runs and the proxy is not draining
+func (p *Proxy) Ready() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.draining {
+		return errors.New("proxy is draining")
+	}
+	if len(p.epochs) == 0 {
+		return errors.New("no proxy epoch is running")
+	}
+	return nil
+}
+
+// ActiveConnections returns 0 since the in-process proxy serves no traffic
+func (p *Proxy) ActiveConnections() (int, error) {
+	return 0, nil
+}
+
+// renderer builds the in-process proxy configuration
+type renderer struct {
+	serviceNode string
+}
+
+func (r renderer) Render(config proxyconfig.ProxyConfig, certHash []byte) (interface{}, error) {
+	return &Config{
+		ProxyConfig: config,
+		ServiceNode: r.serviceNode,
+		CertHash:    certHash,
+	}, nil
+}
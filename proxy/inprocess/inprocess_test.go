@@ -0,0 +1,89 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inprocess
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestAgentRunsInProcessProxy(t *testing.T) {
+	config := proxy.DefaultProxyConfig()
+	node := proxy.Node{Type: proxy.Sidecar, IPAddress: "10.1.1.1", ID: "pod.default", Domain: "default"}
+	backend, err := NewBackend(proxy.BackendOptions{Config: config, Node: node})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inprocessProxy := backend.Proxy.(*Proxy)
+	if err := inprocessProxy.Ready(); err == nil {
+		t.Error("Ready() => expected an error without running epochs")
+	}
+
+	agent := proxy.NewAgent(backend.Proxy, proxy.DefaultRetry, time.Second)
+	watcher := proxy.NewWatcher(config, backend, agent, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watcher.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for agent.Ready() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := agent.Ready(); err != nil {
+		t.Fatalf("Ready() => got error %v, want the in-process proxy ready", err)
+	}
+	current, epoch := inprocessProxy.Current()
+	if epoch != 0 || current == nil || current.ServiceNode != node.ServiceNode() {
+		t.Errorf("Current() => got %#v at epoch %d, want the config of the service node at epoch 0", current, epoch)
+	}
+
+	// the config is unchanged so the proxy is not restarted
+	watcher.Reload()
+	if _, epoch := inprocessProxy.Current(); epoch != 0 {
+		t.Errorf("Current() => got epoch %d after a reload without changes, want 0", epoch)
+	}
+
+	// the proxy drains on termination
+	cancel()
+	<-done
+	if err := inprocessProxy.Ready(); err == nil {
+		t.Error("Ready() => expected an error after the termination")
+	}
+}
+
+func TestRegisterBackend(t *testing.T) {
+	name := "inprocess-test"
+	proxy.RegisterBackend(name, NewBackend)
+
+	found := false
+	for _, backend := range proxy.Backends() {
+		found = found || backend == name
+	}
+	if !found {
+		t.Errorf("Backends() => got %v, want %s registered", proxy.Backends(), name)
+	}
+	if _, err := proxy.NewBackend(name, proxy.BackendOptions{}); err != nil {
+		t.Errorf("NewBackend(%s) => unexpected error %v", name, err)
+	}
+	if _, err := proxy.NewBackend("missing", proxy.BackendOptions{}); err == nil {
+		t.Error("NewBackend(missing) => expected an error for an unregistered backend")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+)
+
+// TracingDriver selects the distributed tracing backend of the proxy
+type TracingDriver string
+
+const (
+	// ZipkinTracer posts the spans to a Zipkin collector
+	ZipkinTracer TracingDriver = "zipkin"
+
+	// JaegerTracer reports the spans to a Jaeger agent over UDP
+	JaegerTracer TracingDriver = "jaeger"
+
+	// LightStepTracer reports the spans to a LightStep collector
+	LightStepTracer TracingDriver = "lightstep"
+)
+
+// meshExtensionKeys are the keys of the mesh config YAML holding the
+// MeshExtensions, which are removed before decoding the MeshConfig
+var meshExtensionKeys = []string{"accessLogFormat", "tcpAccessLog", "tracing", "mirrorPercentages"}
+
+// MeshExtensions holds the mesh settings that the mesh config API lacks.
+// They are set in the mesh config YAML next to the MeshConfig fields.
+type MeshExtensions struct {
+	// AccessLogFormat is the format of the proxy access logs, either
+	// "json" or a template of the proxy command operators. The proxy
+	// default format applies if empty.
+	AccessLogFormat string `json:"accessLogFormat,omitempty"`
+
+	// TCPAccessLog enables the proxy access logs for the TCP connections
+	TCPAccessLog bool `json:"tcpAccessLog,omitempty"`
+
+	// Tracing is the distributed tracing backend of the proxies
+	Tracing TracingConfig `json:"tracing"`
+
+	// MirrorPercentages lists the percentages of the mirrored requests of the
+	// route rules. The proxies read the percentages from their runtime, which
+	// holds the listed percentages only, so the requests of a route rule with
+	// another percentage are not mirrored.
+	MirrorPercentages []int32 `json:"mirrorPercentages,omitempty"`
+}
+
+// TracingConfig describes the distributed tracing backend of the proxies
+type TracingConfig struct {
+	// Driver is the tracer of the proxy
+	Driver TracingDriver `json:"driver"`
+
+	// Address is the address of the Jaeger agent or the LightStep collector;
+	// Zipkin reports to the Zipkin address of the proxy config
+	Address string `json:"address,omitempty"`
+
+	// AccessTokenFile is the path to the LightStep access token
+	AccessTokenFile string `json:"accessTokenFile,omitempty"`
+
+	// Sampling is the percentage of the traced requests, e.g. 0.5; all
+	// requests are traced if unset and none if 0
+	Sampling *float64 `json:"sampling,omitempty"`
+}
+
+// DefaultMeshExtensions reports all the spans to Zipkin
+func DefaultMeshExtensions() MeshExtensions {
+	return MeshExtensions{
+		Tracing: DefaultTracingConfig(),
+	}
+}
+
+// DefaultTracingConfig reports all the spans to Zipkin
+func DefaultTracingConfig() TracingConfig {
+	return TracingConfig{Driver: ZipkinTracer}
+}
+
+// ApplyMeshExtensions returns the MeshExtensions decoded from the mesh config
+// YAML with defaults applied to omitted values
+func ApplyMeshExtensions(yml string) (*MeshExtensions, error) {
+	out := DefaultMeshExtensions()
+	if err := yaml.Unmarshal([]byte(yml), &out); err != nil {
+		return nil, multierror.Prefix(err, "failed to parse mesh extensions")
+	}
+	if err := ValidateMeshExtensions(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ReadMeshExtensions reads the MeshExtensions from a mesh config file, or
+// returns the default MeshExtensions if the file is not set
+func ReadMeshExtensions(filename string) (*MeshExtensions, error) {
+	if filename == "" {
+		out := DefaultMeshExtensions()
+		return &out, nil
+	}
+	yml, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, multierror.Prefix(err, "cannot read mesh config file")
+	}
+	return ApplyMeshExtensions(string(yml))
+}
+
+// ValidateMeshExtensions checks the tracing backend and the mirror
+// percentages, which exclude 0 and 100 that need no runtime
+func ValidateMeshExtensions(extensions *MeshExtensions) (errs error) {
+	if err := ValidateTracingConfig(extensions.Tracing); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "invalid tracing:"))
+	}
+	for _, percent := range extensions.MirrorPercentages {
+		if percent <= 0 || percent >= 100 {
+			errs = multierror.Append(errs, fmt.Errorf("mirror percentage %d must be between 1 and 99", percent))
+		}
+	}
+	return
+}
+
+// ValidateTracingConfig checks that the driver is known, that Jaeger and
+// LightStep have an address, that LightStep has an access token, and the
+// range of the sampling
+func ValidateTracingConfig(tracing TracingConfig) (errs error) {
+	switch tracing.Driver {
+	case ZipkinTracer:
+		if tracing.Address != "" {
+			errs = multierror.Append(errs, errors.New("zipkin reports to the zipkin address of the proxy config"))
+		}
+	case JaegerTracer, LightStepTracer:
+		if err := model.ValidateProxyAddress(tracing.Address); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("invalid %s address:", tracing.Driver)))
+		}
+		if tracing.Driver == LightStepTracer && tracing.AccessTokenFile == "" {
+			errs = multierror.Append(errs, fmt.Errorf("tracing driver %s requires an access token file", tracing.Driver))
+		}
+	default:
+		errs = multierror.Append(errs, fmt.Errorf("unknown tracing driver %q", tracing.Driver))
+	}
+	if tracing.Sampling != nil {
+		if err := model.ValidateFloatPercent(float32(*tracing.Sampling)); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "invalid sampling:"))
+		}
+	}
+	return
+}
+
+// stripMeshExtensions converts the mesh config YAML to JSON without the keys
+// of the MeshExtensions, which the MeshConfig decoding rejects
+func stripMeshExtensions(yml string) (string, error) {
+	js, err := yaml.YAMLToJSON([]byte(yml))
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(js, &fields); err != nil {
+		return "", err
+	}
+	for _, key := range meshExtensionKeys {
+		delete(fields, key)
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
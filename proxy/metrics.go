@@ -0,0 +1,85 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	epochsStarted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pilot_agent_epochs_started_total",
+		Help: "Number of proxy epochs started by the agent.",
+	})
+
+	epochsAborted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pilot_agent_epochs_aborted_total",
+		Help: "Number of proxy epochs aborted by the agent.",
+	})
+
+	epochsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pilot_agent_epochs_failed_total",
+		Help: "Number of proxy epochs that exited with an error.",
+	})
+
+	retryBudget = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pilot_agent_retry_budget",
+		Help: "Number of restart attempts left for the desired proxy configuration.",
+	})
+
+	// lastReload is the time the last started proxy epoch became ready in
+	// Unix nanoseconds, initially the agent start time
+	lastReload = time.Now().UnixNano()
+
+	certChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pilot_agent_cert_changes_total",
+		Help: "Number of changes of the monitored certificates detected by the agent.",
+	})
+
+	certRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pilot_agent_cert_rejections_total",
+		Help: "Number of reloads refused because of an invalid certificate bundle.",
+	})
+
+	certExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pilot_agent_cert_expiry_timestamp_seconds",
+		Help: "Expiry of the earliest expiring monitored certificate in Unix seconds, or 0 if none.",
+	})
+
+	certExpiring = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pilot_agent_cert_expiring",
+		Help: "Whether the expiry of the monitored certificates is imminent (1) or not (0).",
+	})
+
+	sinceLastReload = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pilot_agent_seconds_since_last_reload",
+		Help: "Seconds since the last started proxy epoch became ready, or since the agent start.",
+	}, func() float64 {
+		return time.Since(time.Unix(0, atomic.LoadInt64(&lastReload))).Seconds()
+	})
+)
+
+func init() {
+	prometheus.MustRegister(epochsStarted, epochsAborted, epochsFailed, retryBudget,
+		certChanges, certRejections, certExpiry, certExpiring, sinceLastReload)
+}
+
+// recordReload marks a started proxy epoch becoming ready
+func recordReload() {
+	atomic.StoreInt64(&lastReload, time.Now().UnixNano())
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// ReadyPath is the readiness endpoint of the agent
+	ReadyPath = "/healthz/ready"
+
+	// LivePath is the liveness endpoint of the agent
+	LivePath = "/healthz/live"
+
+	// StatusPath is the status page of the agent
+	StatusPath = "/status"
+
+	// MetricsPath is the Prometheus metrics endpoint of the agent
+	MetricsPath = "/metrics"
+)
+
+// NewStatusHandler creates the HTTP handler of the agent health endpoints.
+// The readiness endpoint succeeds once the proxy runs the desired
+// configuration and reports itself ready. The liveness endpoint fails once the
+// retry budget is exhausted and the agent gives up, but not while it keeps
+// retrying, so that the failing proxies are not restarted together. The status
+// page lists the agent status as JSON.
+// The metrics endpoint exports the agent metrics to Prometheus.
+func NewStatusHandler(agent Agent) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ReadyPath, func(w http.ResponseWriter, _ *http.Request) {
+		if err := agent.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready") // nolint: errcheck
+	})
+	mux.HandleFunc(LivePath, func(w http.ResponseWriter, _ *http.Request) {
+		if agent.Status().Exhausted {
+			http.Error(w, "retry budget exhausted", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "live") // nolint: errcheck
+	})
+	mux.HandleFunc(StatusPath, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(agent.Status()); err != nil {
+			glog.Warningf("Failed to write agent status: %v", err)
+		}
+	})
+	mux.Handle(MetricsPath, promhttp.Handler())
+	return mux
+}
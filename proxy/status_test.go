@@ -0,0 +1,90 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// ReadyTestProxy overrides the readiness of the test proxy
+type ReadyTestProxy struct {
+	TestProxy
+	ready func() error
+}
+
+func (tp ReadyTestProxy) Ready() error {
+	return tp.ready()
+}
+
+func TestStatusHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	running := make(chan struct{})
+	start := func(_ interface{}, _ int, abort <-chan error) error {
+		close(running)
+		return <-abort
+	}
+	var proxyErr error
+	proxy := ReadyTestProxy{TestProxy: TestProxy{start, nil, nil}, ready: func() error { return proxyErr }}
+	a := NewAgent(proxy, testRetry, 0)
+	handler := NewStatusHandler(a)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if code := get(ReadyPath).Code; code != http.StatusServiceUnavailable {
+		t.Errorf("%s => got status %d before the proxy starts, want %d", ReadyPath, code, http.StatusServiceUnavailable)
+	}
+
+	go a.Run(ctx)
+	a.ScheduleConfigUpdate("config")
+	<-running
+
+	// wait for the status to be published by the control loop
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if len(a.Status().Epochs) > 0 {
+			break
+		}
+	}
+	if code := get(ReadyPath).Code; code != http.StatusOK {
+		t.Errorf("%s => got status %d, want %d", ReadyPath, code, http.StatusOK)
+	}
+
+	proxyErr = errors.New("no listeners")
+	if code := get(ReadyPath).Code; code != http.StatusServiceUnavailable {
+		t.Errorf("%s => got status %d for an unready proxy, want %d", ReadyPath, code, http.StatusServiceUnavailable)
+	}
+
+	if code := get(LivePath).Code; code != http.StatusOK {
+		t.Errorf("%s => got status %d, want %d", LivePath, code, http.StatusOK)
+	}
+
+	var status Status
+	if err := json.Unmarshal(get(StatusPath).Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Epochs) != 1 || status.Epochs[0] != 0 || !status.Current {
+		t.Errorf("%s => got %#v, want epoch 0 running the current configuration", StatusPath, status)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/howeyc/fsnotify"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+// Watcher triggers reloads on changes to the proxy config
+type Watcher interface {
+	// Run the watcher loop (blocking call) until the agent terminates
+	Run(context.Context)
+
+	// Reload the agent with the latest configuration
+	Reload()
+}
+
+// CertSource is file source for certificates
+type CertSource struct {
+	// Directory containing certificates
+	Directory string
+	// Files for certificates
+	Files []string
+	// Bundle of the files validated before the reloads, optional
+	Bundle *CertBundle
+}
+
+type watcher struct {
+	agent   Agent
+	config  proxyconfig.ProxyConfig
+	backend Backend
+	certs   []CertSource
+
+	// configSource reloads the proxy config if set
+	configSource *ConfigSource
+
+	// mutex serializes the reloads triggered by the certificates and the
+	// proxy config
+	mutex sync.Mutex
+
+	// certHash is the hash of the certificates at the last reload
+	certHash []byte
+
+	// certExpiry is the earliest expiry of the certificates at the last
+	// reload, or the zero time if none is provisioned
+	certExpiry time.Time
+
+	// certsValid is set once a reload scheduled the proxy with valid
+	// certificates
+	certsValid bool
+}
+
+// NewWatcher creates a new watcher instance from a proxy agent, the backend rendering its configuration, and a set
+// of monitored certificate paths (directories with files in them). The proxy config is reloaded from the config
+// source if set.
+func NewWatcher(config proxyconfig.ProxyConfig, backend Backend, agent Agent, certs []CertSource,
+	configSource *ConfigSource) Watcher {
+	return &watcher{
+		agent:        agent,
+		config:       config,
+		backend:      backend,
+		certs:        certs,
+		configSource: configSource,
+	}
+}
+
+// ConfigSource derives the proxy config from the default proxy config of a
+// mounted mesh config file
+type ConfigSource struct {
+	// MeshConfigFile is the path of the mesh config file
+	MeshConfigFile string
+
+	// Override applies the per-proxy values to the mesh default proxy config
+	Override func(*proxyconfig.ProxyConfig) error
+}
+
+// Load reads the mesh config file and returns the validated proxy config
+func (source ConfigSource) Load() (proxyconfig.ProxyConfig, error) {
+	yaml, err := ioutil.ReadFile(source.MeshConfigFile)
+	if err != nil {
+		return proxyconfig.ProxyConfig{}, multierror.Prefix(err, "cannot read mesh config file")
+	}
+	mesh, err := ApplyMeshConfigDefaults(string(yaml))
+	if err != nil {
+		return proxyconfig.ProxyConfig{}, err
+	}
+
+	config := *mesh.DefaultConfig
+	if source.Override != nil {
+		if err := source.Override(&config); err != nil {
+			return proxyconfig.ProxyConfig{}, err
+		}
+	}
+	if err := model.ValidateProxyConfig(&config); err != nil {
+		return proxyconfig.ProxyConfig{}, err
+	}
+	return config, nil
+}
+
+// keepCommandFields retains the startup values of the proxy config fields
+// that set the proxy command line and the admin API, which are not reloaded
+func keepCommandFields(config *proxyconfig.ProxyConfig, startup proxyconfig.ProxyConfig) {
+	reloaded := *config
+	config.ConfigPath = startup.ConfigPath
+	config.BinaryPath = startup.BinaryPath
+	config.ServiceCluster = startup.ServiceCluster
+	config.AvailabilityZone = startup.AvailabilityZone
+	config.DrainDuration = startup.DrainDuration
+	config.ParentShutdownDuration = startup.ParentShutdownDuration
+	config.CustomConfigFile = startup.CustomConfigFile
+	config.ProxyAdminPort = startup.ProxyAdminPort
+	config.ControlPlaneAuthPolicy = startup.ControlPlaneAuthPolicy
+	if !reflect.DeepEqual(reloaded, *config) {
+		glog.Warningf("Proxy config changes of the command line, the admin port, and the control plane " +
+			"authentication policy require a restart of the agent")
+	}
+}
+
+// defaultMinDelay is the minimum amount of time between delivery of two successive events via updateFunc.
+const defaultMinDelay = 10 * time.Second
+
+func (w *watcher) Run(ctx context.Context) {
+	// agent consumes notifications from the controller
+	agentDone := make(chan struct{})
+	go func() {
+		w.agent.Run(ctx)
+		close(agentDone)
+	}()
+
+	// kickstart the proxy with partial state (in case there are no notifications coming)
+	w.Reload()
+
+	// monitor certificates
+	certDirs := make([]string, 0, len(w.certs))
+	for _, cert := range w.certs {
+		certDirs = append(certDirs, cert.Directory)
+	}
+
+	// monitor the backend inputs with the certificates
+	certDirs = append(certDirs, w.backend.Directories...)
+
+	go watchCerts(ctx, certDirs, watchFileEvents, defaultMinDelay, w.Reload)
+	go w.monitorCertExpiry(ctx)
+
+	// monitor the backend
+	if w.backend.Monitor != nil {
+		go w.backend.Monitor(ctx, w.Reload)
+	}
+
+	// monitor the mesh config
+	if w.configSource != nil {
+		go watchCerts(ctx, []string{path.Dir(w.configSource.MeshConfigFile)}, watchFileEvents, defaultMinDelay,
+			w.reloadProxyConfig)
+	}
+
+	<-ctx.Done()
+
+	// wait for the agent to drain and abort the proxy
+	<-agentDone
+}
+
+// reloadProxyConfig loads the proxy config from the config source and
+// reloads the proxy if the effective proxy config changed
+func (w *watcher) reloadProxyConfig() {
+	config, err := w.configSource.Load()
+	if err != nil {
+		glog.Warningf("Failed to reload proxy config from %s: %v", w.configSource.MeshConfigFile, err)
+		return
+	}
+
+	w.mutex.Lock()
+	keepCommandFields(&config, w.config)
+	changed := !reflect.DeepEqual(config, w.config)
+	if changed {
+		w.config = config
+	}
+	w.mutex.Unlock()
+
+	if changed {
+		glog.Infof("Proxy config changed, reloading")
+		w.Reload()
+	}
+}
+
+func (w *watcher) Reload() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// compute hash of dependent certificates
+	h := sha256.New()
+	for _, cert := range w.certs {
+		generateCertHash(h, cert.Directory, cert.Files)
+	}
+	certHash := h.Sum(nil)
+
+	// the last good epoch keeps running when the certificates change to an
+	// invalid bundle, while the proxy starts regardless until the certificates
+	// are valid. The reloads with the running certificates proceed even if
+	// they are no longer valid, e.g. expired.
+	certsChanged := !bytes.Equal(w.certHash, certHash)
+	expiry, certErr := validateCerts(w.certs)
+	if certErr != nil {
+		switch {
+		case !w.certsValid:
+			glog.Warningf("Reloading with invalid certificates: %v", certErr)
+		case certsChanged:
+			glog.Warningf("Refusing to reload with invalid certificates: %v", certErr)
+			certRejections.Inc()
+			return
+		default:
+			glog.Warningf("Reloading with the running invalid certificates: %v", certErr)
+			expiry = w.certExpiry
+		}
+	}
+
+	config, err := w.backend.Renderer.Render(w.config, certHash)
+	if err != nil {
+		glog.Warningf("Failed to render proxy configuration: %v", err)
+		return
+	}
+
+	if w.certHash != nil && certsChanged {
+		glog.V(2).Info("Certificates changed")
+		certChanges.Inc()
+	}
+	w.certHash = certHash
+	w.certsValid = w.certsValid || certErr == nil
+	if !expiry.Equal(w.certExpiry) {
+		glog.V(2).Infof("Certificates expire at %v", expiry)
+		w.certExpiry = expiry
+		if expiry.IsZero() {
+			certExpiry.Set(0)
+		} else {
+			certExpiry.Set(float64(expiry.Unix()))
+		}
+	}
+	checkCertExpiry(expiry, time.Now())
+
+	w.agent.ScheduleConfigUpdate(config)
+}
+
+// monitorCertExpiry periodically checks the expiry of the certificates of
+// the last reload until the context is cancelled
+func (w *watcher) monitorCertExpiry(ctx context.Context) {
+	ticker := time.NewTicker(certExpiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mutex.Lock()
+			expiry := w.certExpiry
+			w.mutex.Unlock()
+			checkCertExpiry(expiry, time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type watchFileEventsFn func(ctx context.Context, wch <-chan *fsnotify.FileEvent,
+	minDelay time.Duration, notifyFn func())
+
+// watchFileEvents watches for changes on a channel and notifies via notifyFn().
+// The function batches changes so that related changes are processed together.
+// The function ensures that notifyFn() is called no more than one time per minDelay.
+// The function does not return until the the context is cancelled.
+func watchFileEvents(ctx context.Context, wch <-chan *fsnotify.FileEvent, minDelay time.Duration, notifyFn func()) {
+	// timer and channel for managing minDelay.
+	var timeChan <-chan time.Time
+	var timer *time.Timer
+
+	for {
+		select {
+		case ev := <-wch:
+			glog.Infof("watchFileEvents: %s", ev.String())
+			if timer != nil {
+				continue
+			}
+			// create new timer
+			timer = time.NewTimer(minDelay)
+			timeChan = timer.C
+		case <-timeChan:
+			// reset timer
+			timeChan = nil
+			timer.Stop()
+			timer = nil
+
+			glog.Info("watchFileEvents: notifying")
+			notifyFn()
+		case <-ctx.Done():
+			glog.V(2).Info("watchFileEvents has terminated")
+			return
+		}
+	}
+}
+
+// watchCerts watches all certificate directories and calls the provided
+// `updateFunc` method when changes are detected. This method is blocking
+// so it should be run as a goroutine.
+// updateFunc will not be called more than one time per minDelay.
+func watchCerts(ctx context.Context, certsDirs []string, watchFileEventsFn watchFileEventsFn,
+	minDelay time.Duration, updateFunc func()) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Warningf("failed to create a watcher for certificate files: %v", err)
+		return
+	}
+	defer func() {
+		if err := fw.Close(); err != nil {
+			glog.Warningf("closing watcher encounters an error %v", err)
+		}
+	}()
+
+	// watch all directories
+	for _, d := range certsDirs {
+		if err := fw.Watch(d); err != nil {
+			glog.Warningf("watching %s encounters an error %v", d, err)
+			return
+		}
+	}
+	watchFileEventsFn(ctx, fw.Event, minDelay, updateFunc)
+}
+
+func generateCertHash(h hash.Hash, certsDir string, files []string) {
+	if _, err := os.Stat(certsDir); os.IsNotExist(err) {
+		return
+	}
+
+	for _, file := range files {
+		filename := path.Join(certsDir, file)
+		bs, err := ioutil.ReadFile(filename)
+		if err != nil {
+			// glog.Warningf("failed to read file %q", filename)
+			continue
+		}
+		if _, err := h.Write(bs); err != nil {
+			glog.Warning(err)
+		}
+	}
+}
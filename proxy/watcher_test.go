@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package envoy
+package proxy
 
 import (
 	"bytes"
@@ -21,13 +21,13 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
-	"reflect"
 	"testing"
 	"time"
 
 	"github.com/howeyc/fsnotify"
+	dto "github.com/prometheus/client_model/go"
 
-	"istio.io/pilot/proxy"
+	proxyconfig "istio.io/api/proxy/v1/config"
 )
 
 type TestAgent struct {
@@ -42,6 +42,28 @@ func (ta TestAgent) Run(ctx context.Context) {
 	<-ctx.Done()
 }
 
+func (ta TestAgent) Status() Status {
+	return Status{}
+}
+
+func (ta TestAgent) Ready() error {
+	return nil
+}
+
+// TestRenderer renders the certificate hash, or fails with the error if set
+type TestRenderer struct {
+	err error
+}
+
+func (tr TestRenderer) Render(_ proxyconfig.ProxyConfig, certHash []byte) (interface{}, error) {
+	if tr.err != nil {
+		return nil, tr.err
+	}
+	return certHash, nil
+}
+
+var testBackend = Backend{Renderer: TestRenderer{}}
+
 func TestRunReload(t *testing.T) {
 	called := make(chan bool)
 	agent := TestAgent{
@@ -49,12 +71,9 @@ func TestRunReload(t *testing.T) {
 			called <- true
 		},
 	}
-	config := proxy.DefaultProxyConfig()
-	node := proxy.Node{
-		Type: proxy.Ingress,
-		ID:   "random",
-	}
-	watcher := NewWatcher(config, agent, node, []CertSource{{Directory: "random"}}, nil)
+	config := DefaultProxyConfig()
+	certs := []CertSource{{Directory: "random"}}
+	watcher := NewWatcher(config, testBackend, agent, certs, nil)
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// watcher starts agent and schedules a config update
@@ -107,7 +126,7 @@ func TestWatchCerts_Multiple(t *testing.T) {
 }
 
 func TestWatchCerts(t *testing.T) {
-	name, err := ioutil.TempDir("testdata", "certs")
+	name, err := ioutil.TempDir("", "certs")
 	if err != nil {
 		t.Errorf("failed to create a temp dir: %v", err)
 	}
@@ -131,7 +150,7 @@ func TestWatchCerts(t *testing.T) {
 
 	// make a change to the watched dir
 	if _, err := ioutil.TempFile(name, "test.file"); err != nil {
-		t.Errorf("failed to create a temp file in testdata/certs: %v", err)
+		t.Errorf("failed to create a temp file in the temp dir: %v", err)
 	}
 
 	select {
@@ -148,7 +167,7 @@ func TestWatchCerts(t *testing.T) {
 }
 
 func TestGenerateCertHash(t *testing.T) {
-	name, err := ioutil.TempDir("testdata", "certs")
+	name, err := ioutil.TempDir("", "certs")
 	if err != nil {
 		t.Errorf("failed to create a temp dir: %v", err)
 	}
@@ -159,7 +178,7 @@ func TestGenerateCertHash(t *testing.T) {
 	}()
 
 	h := sha256.New()
-	authFiles := []string{proxy.CertChainFilename, proxy.KeyFilename, proxy.RootCertFilename}
+	authFiles := []string{CertChainFilename, KeyFilename, RootCertFilename}
 	for _, file := range authFiles {
 		content := []byte(file)
 		if err := ioutil.WriteFile(path.Join(name, file), content, 0644); err != nil {
@@ -185,60 +204,104 @@ func TestGenerateCertHash(t *testing.T) {
 	}
 }
 
-func TestEnvoyArgs(t *testing.T) {
-	config := proxy.DefaultProxyConfig()
-	config.ServiceCluster = "my-cluster"
-	config.AvailabilityZone = "my-zone"
+func TestReloadCertChanges(t *testing.T) {
+	name, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Errorf("failed to create a temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(name); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
 
-	test := envoy{config: config, node: "my-node"}
-	testProxy := NewProxy(config, "my-node")
-	if !reflect.DeepEqual(testProxy, test) {
-		t.Errorf("unexpected struct got\n%v\nwant\n%v", testProxy, test)
+	changes := func() float64 {
+		var metric dto.Metric
+		if err := certChanges.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		return metric.GetCounter().GetValue()
+	}
+	writeCert := func(content string) {
+		if err := ioutil.WriteFile(path.Join(name, CertChainFilename), []byte(content), 0644); err != nil {
+			t.Errorf("failed to write file %s (error %v)", CertChainFilename, err)
+		}
 	}
 
-	got := test.args("test.json", 5)
-	want := []string{
-		"-c", "test.json",
-		"--restart-epoch", "5",
-		"--drain-time-s", "2",
-		"--parent-shutdown-time-s", "3",
-		"--service-cluster", "my-cluster",
-		"--service-node", "my-node",
-		"--service-zone", "my-zone",
+	agent := TestAgent{schedule: func(_ interface{}) {}}
+	certs := []CertSource{{Directory: name, Files: []string{CertChainFilename}}}
+	watcher := NewWatcher(DefaultProxyConfig(), testBackend, agent, certs, nil)
+	initial := changes()
+
+	writeCert("cert")
+	watcher.Reload()
+	watcher.Reload()
+	if got := changes() - initial; got != 0 {
+		t.Errorf("Reload() => got %v certificate changes without a change, want 0", got)
 	}
-	if !reflect.DeepEqual(got, want) {
-		t.Errorf("envoyArgs() => got %v, want %v", got, want)
+
+	writeCert("rotated cert")
+	watcher.Reload()
+	if got := changes() - initial; got != 1 {
+		t.Errorf("Reload() => got %v certificate changes, want 1", got)
 	}
 }
 
-func TestEnvoyRun(t *testing.T) {
-	config := proxy.DefaultProxyConfig()
-	dir, err := os.Getwd()
+func TestReloadProxyConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("failed to create a temp dir: %v", err)
 	}
-	config.BinaryPath = path.Join(dir, "envoy")
-	config.ConfigPath = "tmp"
-
-	envoyConfig := buildConfig(config, nil)
-	proxy := envoy{config: config, node: "my-node", extraArgs: []string{"--mode", "validate"}}
-	abortCh := make(chan error, 1)
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove temp dir: %v", err)
+		}
+	}()
 
-	if err = proxy.Run(nil, 0, abortCh); err == nil {
-		t.Error("expected error on nil config")
+	meshFile := path.Join(dir, "mesh")
+	writeMesh := func(content string) {
+		if err := ioutil.WriteFile(meshFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file %s (error %v)", meshFile, err)
+		}
+	}
+	source := &ConfigSource{
+		MeshConfigFile: meshFile,
+		Override: func(config *proxyconfig.ProxyConfig) error {
+			config.ServiceCluster = "reviews"
+			return nil
+		},
 	}
 
-	if err = proxy.Run(envoyConfig, 0, abortCh); err != nil {
-		t.Error(err)
+	writeMesh("defaultConfig:\n  connectTimeout: 2s\n")
+	startup, err := source.Load()
+	if err != nil {
+		t.Fatalf("Load() => unexpected error %v", err)
 	}
+	if startup.ServiceCluster != "reviews" || startup.ConnectTimeout.Seconds != 2 {
+		t.Errorf("Load() => got %#v, want the mesh config with the overrides", startup)
+	}
+
+	updates := 0
+	agent := TestAgent{schedule: func(_ interface{}) { updates++ }}
+	w := NewWatcher(startup, testBackend, agent, nil, source).(*watcher)
 
-	proxy.Cleanup(0)
+	// unchanged config
+	w.reloadProxyConfig()
+	if updates != 0 {
+		t.Errorf("reloadProxyConfig() => got %d updates for the same config, want 0", updates)
+	}
 
-	badConfig := config
-	badConfig.ConfigPath = ""
-	proxy.config = badConfig
+	// invalid config
+	writeMesh("defaultConfig:\n  connectTimeout: -2s\n")
+	w.reloadProxyConfig()
+	if updates != 0 {
+		t.Errorf("reloadProxyConfig() => got %d updates for an invalid config, want 0", updates)
+	}
 
-	if err = proxy.Run(envoyConfig, 0, abortCh); err == nil {
-		t.Errorf("expected error on bad config path")
+	// changed config with a change of the command line that is not applied
+	writeMesh("defaultConfig:\n  connectTimeout: 3s\n  binaryPath: /usr/bin/envoy\n")
+	w.reloadProxyConfig()
+	if updates != 1 || w.config.ConnectTimeout.Seconds != 3 || w.config.BinaryPath != startup.BinaryPath {
+		t.Errorf("reloadProxyConfig() => got %d updates and config %#v, want the new timeout only", updates, w.config)
 	}
 }